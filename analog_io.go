@@ -1,16 +1,52 @@
 package goduino
 
-// AnalogRead retrieves value from analog pin.
-// Returns -1 if the response from the board has timed out
+import "fmt"
+
+// AnalogRead retrieves value from analog pin, numbered by analog channel
+// (0 for A0). Returns ErrAnalogChannel if the board has no such channel.
 func (ino *Goduino) AnalogRead(pin int) (value int, err error) {
-	p := ino.digitalPin(pin)
+	n, err := ino.A(pin)
+	if err != nil {
+		return
+	}
+	p, err := ino.pin(n)
+	if err != nil {
+		return
+	}
 	// Check if pin is configured as analog
-	if ino.board.Pins()[p].Mode != Analog {
+	if p.Mode != Analog {
 		if err = ino.PinMode(pin, Analog); err != nil {
 			return
 		}
+		if p, err = ino.pin(n); err != nil {
+			return
+		}
 	}
-	value = ino.board.Pins()[p].Value
-	ino.logger.Printf("analogRead(%d) -> %d\r\n", pin, value)
+	value = p.Value
+	ino.logger.Debugf("analogRead(%d) -> %d", pin, value)
 	return
 }
+
+// AnalogWrite writes an analog value (PWM wave) to a pin, switching it to PWM
+// mode first if needed. value must fit in the PWM resolution of the pin
+// reported by the board, 0-255 for the usual 8 bits.
+func (ino *Goduino) AnalogWrite(pin, value int) error {
+	if err := ino.checkMode(pin, Pwm); err != nil {
+		return err
+	}
+	if res := ino.board.Resolution(pin, Pwm); value < 0 || (res > 0 && value >= 1<<uint(res)) {
+		return fmt.Errorf("value %d out of range for %d-bit PWM on pin %d", value, res, pin)
+	}
+	// Check if pin is configured as pwm
+	if p, _ := ino.board.Pin(pin); p.Mode != Pwm {
+		if err := ino.PinMode(pin, Pwm); err != nil {
+			return err
+		}
+	}
+	ino.logger.Debugf("analogWrite(%d, %d)", pin, value)
+	if err := ino.board.AnalogWrite(pin, value); err != nil {
+		return err
+	}
+	ino.recordOutput(pin, Pwm, value)
+	return nil
+}
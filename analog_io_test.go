@@ -0,0 +1,84 @@
+package goduino
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/argandas/goduino/firmata/sim"
+)
+
+func TestAnalogMapping(t *testing.T) {
+	// A Mega has 54 digital pins followed by A0-A15
+	pins := []sim.Pin{}
+	for i := 0; i < 70; i++ {
+		p := sim.Pin{Modes: map[int]int{Input: 1, Output: 1}, AnalogChannel: sim.NoChannel}
+		if i >= 54 {
+			p.Modes[Analog] = 10
+			p.AnalogChannel = i - 54
+		}
+		pins = append(pins, p)
+	}
+	board := sim.New(pins)
+	arduino := connectSim(t, board)
+
+	if pin, err := arduino.A(15); err != nil || pin != 69 {
+		t.Errorf("A(15) = %d, %v, want 69", pin, err)
+	}
+	if _, err := arduino.A(16); !errors.Is(err, ErrAnalogChannel) {
+		t.Errorf("A(16) error = %v, want %v", err, ErrAnalogChannel)
+	}
+	if _, err := arduino.AnalogRead(16); !errors.Is(err, ErrAnalogChannel) {
+		t.Errorf("AnalogRead(16) error = %v, want %v", err, ErrAnalogChannel)
+	}
+
+	values := make(chan int, 4)
+	arduino.OnAnalogChange(12, 1, func(value int) { values <- value })
+	if _, err := arduino.AnalogRead(12); err != nil {
+		t.Fatal(err)
+	}
+	board.SetAnalog(12, 300)
+	select {
+	case value := <-values:
+		if value != 300 {
+			t.Errorf("A12 value = %d, want 300", value)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("A12 change not reported")
+	}
+	if p := board.Pin(66); p.Mode != Analog {
+		t.Errorf("pin 66 mode = %d, want analog", p.Mode)
+	}
+	if v, err := arduino.AnalogRead(12); err != nil || v != 300 {
+		t.Errorf("AnalogRead(12) = %d, %v, want 300", v, err)
+	}
+}
+
+func TestAnalogWriteExtended(t *testing.T) {
+	// A board with 50 pins that all have 16-bit PWM, except 8-bit pin 3
+	pins := []sim.Pin{}
+	for i := 0; i < 50; i++ {
+		pins = append(pins, sim.Pin{Modes: map[int]int{Output: 1, Pwm: 16}, AnalogChannel: sim.NoChannel})
+	}
+	pins[3].Modes[Pwm] = 8
+	board := sim.New(pins)
+	arduino := connectSim(t, board)
+
+	if err := arduino.AnalogWrite(3, 256); err == nil {
+		t.Error("AnalogWrite(3, 256) succeeded on an 8-bit pin")
+	}
+	tests := []struct{ pin, value int }{
+		{3, 200},     // analog message
+		{5, 0x4000},  // value too large for an analog message
+		{44, 60000},  // pin above 15
+		{44, 0xFFFF}, // both
+	}
+	for _, tt := range tests {
+		if err := arduino.AnalogWrite(tt.pin, tt.value); err != nil {
+			t.Fatal(err)
+		}
+		if p := board.Pin(tt.pin); p.Mode != Pwm || p.Value != tt.value {
+			t.Errorf("AnalogWrite(%d, %d): pin mode %d value %d", tt.pin, tt.value, p.Mode, p.Value)
+		}
+	}
+}
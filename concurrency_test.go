@@ -0,0 +1,144 @@
+package goduino
+
+import (
+	"context"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/argandas/goduino/firmata"
+	"github.com/argandas/goduino/firmata/sim"
+)
+
+func TestConcurrentUse(t *testing.T) {
+	board := sim.NewUno()
+	arduino, err := New("test", WithConn(board))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := arduino.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer arduino.Disconnect()
+	arduino.OnDigitalChange(2, func(old, new int) {})
+	arduino.OnAnalogChange(0, 1, func(int) {})
+	if err := arduino.PinMode(2, Input); err != nil {
+		t.Fatal(err)
+	}
+	if err := arduino.PinMode(0, Analog); err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for range arduino.Events() {
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				arduino.DigitalWrite(8+g%6, i&1)
+				arduino.DigitalRead(2)
+				arduino.AnalogRead(0)
+				arduino.board.Pins()
+				board.SetDigital(2, i&1)
+				board.SetAnalog(0, i*g)
+				if i%10 == 0 {
+					ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+					if err := arduino.SyncPins(ctx); err != nil {
+						t.Error(err)
+					}
+					cancel()
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	// The last write to every pin of a port wins
+	for pin := 8; pin < 14; pin++ {
+		if err := arduino.DigitalWrite(pin, 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if _, err := arduino.PinState(ctx, 13); err != nil {
+		t.Fatal(err)
+	}
+	for pin := 8; pin < 14; pin++ {
+		if v := board.Pin(pin).Value; v != 1 {
+			t.Errorf("pin %d = %d, want 1", pin, v)
+		}
+	}
+}
+
+func TestConcurrentReconnect(t *testing.T) {
+	boards := make(chan *sim.Board, 4)
+	arduino, err := New("test", WithPort("sim"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	arduino.openSP = func(context.Context, string) (io.ReadWriteCloser, error) {
+		b := sim.NewUno()
+		boards <- b
+		return b, nil
+	}
+	arduino.SetReconnect(ReconnectConfig{MinBackoff: time.Millisecond, MaxBackoff: 10 * time.Millisecond})
+	states := make(chan ConnState, 8)
+	arduino.OnStateChange(func(state ConnState, err error) { states <- state })
+	if err := arduino.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer arduino.Disconnect()
+	<-states
+	first := <-boards
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			f := arduino.board.(*firmata.Firmata)
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				// Calls fail while reconnecting, they must not race
+				arduino.DigitalWrite(13, 1)
+				arduino.AnalogRead(0)
+				arduino.board.Pins()
+				f.FirmwareName()
+				f.ProtocolVersion()
+				arduino.State()
+			}
+		}()
+	}
+
+	first.Close()
+	for _, want := range []ConnState{StateReconnecting, StateConnected} {
+		select {
+		case s := <-states:
+			if s != want {
+				t.Fatalf("state = %v, want %v", s, want)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("no %v state", want)
+		}
+	}
+	close(stop)
+	wg.Wait()
+
+	if err := arduino.DigitalWrite(13, 1); err != nil {
+		t.Fatal(err)
+	}
+	if name := arduino.board.(*firmata.Firmata).FirmwareName(); name != "StandardFirmata.ino" {
+		t.Errorf("FirmwareName() = %q", name)
+	}
+}
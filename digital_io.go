@@ -6,25 +6,41 @@ package goduino
 // its voltage will be set to the corresponding value:
 // 5V (or 3.3V on 3.3V boards) for HIGH, 0V (ground) for LOW.
 func (ino *Goduino) DigitalWrite(pin, value int) error {
+	p, err := ino.pin(pin)
+	if err != nil {
+		return err
+	}
 	// Check if pin is configured as analog
-	if ino.board.Pins()[pin].Mode != Output {
+	if p.Mode != Output {
 		if err := ino.PinMode(pin, Output); err != nil {
 			return err
 		}
 	}
-	ino.logger.Printf("digitalWrite(%d, %d)\r\n", pin, value)
-	return ino.board.DigitalWrite(pin, value)
+	ino.logger.Debugf("digitalWrite(%d, %d)", pin, value)
+	if err := ino.board.DigitalWrite(pin, value); err != nil {
+		return err
+	}
+	ino.recordOutput(pin, Output, value)
+	return nil
 }
 
 // DigitalRead reads the value from a specified digital pin, either HIGH or LOW.
+// Pins not configured as Input or Pullup are switched to Input.
 func (ino *Goduino) DigitalRead(pin int) (value int, err error) {
-	// Check if pin is configured as input
-	if ino.board.Pins()[pin].Mode != Input {
+	p, err := ino.pin(pin)
+	if err != nil {
+		return
+	}
+	// Check if pin is configured as input, with or without pull-up
+	if mode := p.Mode; mode != Input && mode != Pullup {
 		if err = ino.PinMode(pin, Input); err != nil {
 			return
 		}
+		if p, err = ino.pin(pin); err != nil {
+			return
+		}
 	}
-	value = ino.board.Pins()[pin].Value
-	ino.logger.Printf("digitalRead(%d) -> %d\r\n", pin, value)
+	value = p.Value
+	ino.logger.Debugf("digitalRead(%d) -> %d", pin, value)
 	return
 }
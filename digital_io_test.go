@@ -0,0 +1,32 @@
+package goduino
+
+import (
+	"testing"
+
+	"github.com/argandas/goduino/firmata/sim"
+)
+
+func TestPullup(t *testing.T) {
+	board := sim.NewUno()
+	arduino := connectSim(t, board)
+	if err := arduino.PinMode(4, Pullup); err != nil {
+		t.Fatal(err)
+	}
+	syncPin(t, arduino, 4)
+	if p := board.Pin(4); p.Mode != Pullup {
+		t.Errorf("board pin 4 mode = %d, want INPUT_PULLUP", p.Mode)
+	}
+	// The pull-up holds the open input high
+	if v, err := arduino.DigitalRead(4); err != nil || v != 1 {
+		t.Errorf("DigitalRead(4) = %d, %v, want 1", v, err)
+	}
+	board.SetDigital(4, 0)
+	syncPin(t, arduino, 4)
+	if v, err := arduino.DigitalRead(4); err != nil || v != 0 {
+		t.Errorf("DigitalRead(4) = %d, %v after pulling low, want 0", v, err)
+	}
+	// DigitalRead keeps the pull-up on
+	if p := board.Pin(4); p.Mode != Pullup {
+		t.Errorf("board pin 4 mode = %d after DigitalRead, want INPUT_PULLUP", p.Mode)
+	}
+}
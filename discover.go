@@ -0,0 +1,280 @@
+package goduino
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/argandas/goduino/firmata"
+	"github.com/tarm/serial"
+)
+
+// ErrNoBoard is returned when no discovered board matches the selection
+var ErrNoBoard = errors.New("no matching board found")
+
+// PortInfo describes a serial port found by Discover
+type PortInfo struct {
+	Port         string // device path, e.g. /dev/ttyACM0
+	VendorID     string // USB vendor ID in hex, e.g. "2341", empty if not USB
+	ProductID    string // USB product ID in hex
+	SerialNumber string // USB serial number
+	Manufacturer string // USB manufacturer string
+	Product      string // USB product string
+
+	// Set when probing
+	FirmwareName    string
+	ProtocolVersion string
+	ProbeErr        error // why the port didn't answer the handshake
+}
+
+// USB reports whether the port belongs to a USB device.
+func (p PortInfo) USB() bool { return p.VendorID != "" }
+
+// Discovery controls how Discover finds boards.
+type Discovery struct {
+	// SysfsRoot is where sysfs is mounted, tests point it to a fake tree
+	SysfsRoot string
+	// DevDir holds the device nodes
+	DevDir string
+	// Probe runs the Firmata handshake on every port to read the firmware
+	// name and protocol version
+	Probe bool
+	// ProbeTimeout bounds the handshake on each port
+	ProbeTimeout time.Duration
+	// Open opens a port to probe it, nil opens it as a serial port at
+	// DefaultBaud
+	Open func(ctx context.Context, port string) (io.ReadWriteCloser, error)
+}
+
+// DefaultDiscovery reads /sys and doesn't probe ports.
+var DefaultDiscovery = Discovery{
+	SysfsRoot:    "/sys",
+	DevDir:       "/dev",
+	ProbeTimeout: 5 * time.Second,
+}
+
+// Discover lists the serial ports a board may be attached to, as configured
+// by DefaultDiscovery.
+func Discover(ctx context.Context) ([]PortInfo, error) {
+	return DefaultDiscovery.Discover(ctx)
+}
+
+// Discover lists the tty devices of USB serial adapters and boards, and the
+// ttyAMA UARTs of single board computers, sorted by port. USB devices are
+// described by the attributes sysfs reports for them. With Probe set the
+// ports are probed in parallel; a port that doesn't answer keeps its
+// ProbeErr and is still listed.
+func (d Discovery) Discover(ctx context.Context) ([]PortInfo, error) {
+	dir := filepath.Join(d.SysfsRoot, "class", "tty")
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("discovering serial ports: %w", err)
+	}
+	ports := []PortInfo{}
+	for _, entry := range entries {
+		device, err := filepath.EvalSymlinks(filepath.Join(dir, entry.Name(), "device"))
+		if err != nil {
+			// Virtual terminals and ptys have no device
+			continue
+		}
+		info := PortInfo{Port: filepath.Join(d.DevDir, entry.Name())}
+		if usb := d.usbDevice(device); usb != "" {
+			info.VendorID = readAttr(usb, "idVendor")
+			info.ProductID = readAttr(usb, "idProduct")
+			info.SerialNumber = readAttr(usb, "serial")
+			info.Manufacturer = readAttr(usb, "manufacturer")
+			info.Product = readAttr(usb, "product")
+		} else if !strings.HasPrefix(entry.Name(), "ttyAMA") {
+			// Skip the legacy ttyS ports the kernel registers whether
+			// they exist or not
+			continue
+		}
+		ports = append(ports, info)
+	}
+	sort.Slice(ports, func(i, j int) bool { return ports[i].Port < ports[j].Port })
+	if d.Probe {
+		var wg sync.WaitGroup
+		for i := range ports {
+			wg.Add(1)
+			go func(p *PortInfo) {
+				defer wg.Done()
+				d.probe(ctx, p)
+			}(&ports[i])
+		}
+		wg.Wait()
+	}
+	return ports, ctx.Err()
+}
+
+// usbDevice returns the sysfs directory of the USB device device belongs
+// to, the first parent with a vendor ID, or "" if there is none.
+func (d Discovery) usbDevice(device string) string {
+	root := filepath.Clean(d.SysfsRoot)
+	if r, err := filepath.EvalSymlinks(root); err == nil {
+		root = r
+	}
+	for dir := device; strings.HasPrefix(dir, root+string(filepath.Separator)); dir = filepath.Dir(dir) {
+		if _, err := os.Stat(filepath.Join(dir, "idVendor")); err == nil {
+			return dir
+		}
+	}
+	return ""
+}
+
+// probe runs the Firmata handshake on p to read its firmware.
+func (d Discovery) probe(ctx context.Context, p *PortInfo) {
+	if d.ProbeTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d.ProbeTimeout)
+		defer cancel()
+	}
+	open := d.Open
+	if open == nil {
+		open = openSerial
+	}
+	conn, err := open(ctx, p.Port)
+	if err != nil {
+		p.ProbeErr = err
+		return
+	}
+	board := firmata.New()
+	if err := board.ConnectContext(ctx, conn); err != nil {
+		// The board closed conn
+		p.ProbeErr = err
+		return
+	}
+	p.FirmwareName = board.FirmwareName()
+	p.ProtocolVersion = board.ProtocolVersion()
+	board.Disconnect()
+}
+
+// openSerial opens port at DefaultBaud.
+func openSerial(ctx context.Context, port string) (io.ReadWriteCloser, error) {
+	return serial.OpenPort(&serial.Config{Name: port, Baud: DefaultBaud})
+}
+
+// readAttr reads a sysfs attribute of dir, "" if it doesn't exist.
+func readAttr(dir, name string) string {
+	data, err := os.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// portMatch selects a discovered board by USB serial number or firmware
+// name.
+type portMatch struct {
+	serialNumber string
+	firmware     string
+}
+
+func (m portMatch) matches(p PortInfo) bool {
+	return (m.serialNumber == "" || p.SerialNumber == m.serialNumber) &&
+		(m.firmware == "" || p.FirmwareName == m.firmware)
+}
+
+// heldPorts maps the ports opened by connected or reconnecting boards to
+// the board holding them, findPort leaves them alone.
+var heldPorts = struct {
+	sync.Mutex
+	ports map[string]*Goduino
+}{ports: map[string]*Goduino{}}
+
+// holdPort records that ino opened port, releasing the port it held before.
+func holdPort(ino *Goduino, port string) {
+	heldPorts.Lock()
+	defer heldPorts.Unlock()
+	for p, holder := range heldPorts.ports {
+		if holder == ino {
+			delete(heldPorts.ports, p)
+		}
+	}
+	heldPorts.ports[port] = ino
+}
+
+// releasePort forgets the port held by ino, if any.
+func releasePort(ino *Goduino) {
+	heldPorts.Lock()
+	defer heldPorts.Unlock()
+	for p, holder := range heldPorts.ports {
+		if holder == ino {
+			delete(heldPorts.ports, p)
+		}
+	}
+}
+
+// heldByOther reports whether port is held by a board other than ino.
+func heldByOther(ino *Goduino, port string) bool {
+	heldPorts.Lock()
+	defer heldPorts.Unlock()
+	holder, ok := heldPorts.ports[port]
+	return ok && holder != ino
+}
+
+// findPort returns the first port discovered by ino that matches its
+// selection, skipping the ports other boards hold. Ports are matched by
+// their sysfs attributes first. When selecting by firmware name the board
+// found before is recognized by its USB serial number, and the other ports
+// are probed one at a time only if it is gone.
+func (ino *Goduino) findPort(ctx context.Context) (string, error) {
+	d := ino.discovery
+	d.Probe = false
+	if d.Open == nil {
+		d.Open = ino.openSP
+	}
+	ports, err := d.Discover(ctx)
+	if err != nil {
+		return "", err
+	}
+	candidates := []PortInfo{}
+	for _, p := range ports {
+		if heldByOther(ino, p.Port) {
+			continue
+		}
+		if ino.match.serialNumber == "" || p.SerialNumber == ino.match.serialNumber {
+			candidates = append(candidates, p)
+		}
+	}
+	if ino.match.firmware == "" && len(candidates) > 0 {
+		return candidates[0].Port, nil
+	}
+	ino.mu.Lock()
+	found := ino.foundSerial
+	ino.mu.Unlock()
+	if found != "" {
+		for _, p := range candidates {
+			if p.SerialNumber == found {
+				return p.Port, nil
+			}
+		}
+	}
+	if ino.match.firmware != "" {
+		for _, p := range candidates {
+			d.probe(ctx, &p)
+			if ino.match.matches(p) {
+				ino.mu.Lock()
+				ino.foundSerial = p.SerialNumber
+				ino.mu.Unlock()
+				return p.Port, nil
+			}
+			if ctx.Err() != nil {
+				return "", ctx.Err()
+			}
+		}
+	}
+	switch {
+	case ino.match.serialNumber != "" && ino.match.firmware != "":
+		return "", fmt.Errorf("%w: serial number %q running %q", ErrNoBoard, ino.match.serialNumber, ino.match.firmware)
+	case ino.match.serialNumber != "":
+		return "", fmt.Errorf("%w: serial number %q", ErrNoBoard, ino.match.serialNumber)
+	}
+	return "", fmt.Errorf("%w: firmware %q", ErrNoBoard, ino.match.firmware)
+}
@@ -0,0 +1,210 @@
+package goduino
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/argandas/goduino/firmata/sim"
+)
+
+// fakeSysfs builds a sysfs tree with an Arduino on ttyACM0, an FTDI adapter
+// on ttyUSB0, a UART on ttyAMA0, a legacy ttyS0 and a virtual tty1.
+func fakeSysfs(t *testing.T) string {
+	t.Helper()
+	root := t.TempDir()
+	dir := func(path string, attrs map[string]string) {
+		if err := os.MkdirAll(filepath.Join(root, path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		for name, value := range attrs {
+			if err := os.WriteFile(filepath.Join(root, path, name), []byte(value+"\n"), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	tty := func(name, device string) {
+		dir(filepath.Join("class/tty", name), nil)
+		if device == "" {
+			return
+		}
+		if err := os.Symlink(filepath.Join("../../..", device), filepath.Join(root, "class/tty", name, "device")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	dir("devices/pci0/usb1/1-1", map[string]string{
+		"idVendor":     "2341",
+		"idProduct":    "0043",
+		"serial":       "75830303934351F0A1B2",
+		"manufacturer": "Arduino (www.arduino.cc)",
+		"product":      "Arduino Uno",
+	})
+	dir("devices/pci0/usb1/1-1/1-1:1.0", nil)
+	dir("devices/pci0/usb1/1-2", map[string]string{
+		"idVendor":  "0403",
+		"idProduct": "6001",
+		"serial":    "FTX9K2",
+	})
+	dir("devices/pci0/usb1/1-2/1-2:1.0/ttyUSB0", nil)
+	dir("devices/platform/serial8250", nil)
+	dir("devices/platform/uart0", nil)
+	tty("ttyACM0", "devices/pci0/usb1/1-1/1-1:1.0")
+	tty("ttyUSB0", "devices/pci0/usb1/1-2/1-2:1.0/ttyUSB0")
+	tty("ttyS0", "devices/platform/serial8250")
+	tty("ttyAMA0", "devices/platform/uart0")
+	tty("tty1", "")
+	return root
+}
+
+func TestDiscover(t *testing.T) {
+	d := Discovery{SysfsRoot: fakeSysfs(t), DevDir: "/dev"}
+	ports, err := d.Discover(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []PortInfo{
+		{Port: "/dev/ttyACM0", VendorID: "2341", ProductID: "0043", SerialNumber: "75830303934351F0A1B2", Manufacturer: "Arduino (www.arduino.cc)", Product: "Arduino Uno"},
+		{Port: "/dev/ttyAMA0"},
+		{Port: "/dev/ttyUSB0", VendorID: "0403", ProductID: "6001", SerialNumber: "FTX9K2"},
+	}
+	if len(ports) != len(want) {
+		t.Fatalf("Discover() = %+v, want %+v", ports, want)
+	}
+	for i := range want {
+		if ports[i] != want[i] {
+			t.Errorf("port %d = %+v, want %+v", i, ports[i], want[i])
+		}
+	}
+}
+
+func TestDiscoverProbe(t *testing.T) {
+	d := Discovery{
+		SysfsRoot:    fakeSysfs(t),
+		DevDir:       "/dev",
+		Probe:        true,
+		ProbeTimeout: time.Second,
+		Open: func(ctx context.Context, port string) (io.ReadWriteCloser, error) {
+			if port == "/dev/ttyUSB0" {
+				return sim.NewUno(), nil
+			}
+			return nil, errors.New("no such device")
+		},
+	}
+	ports, err := d.Discover(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range ports {
+		switch {
+		case p.Port == "/dev/ttyUSB0":
+			if p.FirmwareName != "StandardFirmata.ino" || p.ProtocolVersion != "2.5" || p.ProbeErr != nil {
+				t.Errorf("probed %+v", p)
+			}
+		case p.ProbeErr == nil:
+			t.Errorf("%s answered the probe", p.Port)
+		}
+	}
+}
+
+func TestNewSelectsBoard(t *testing.T) {
+	root := fakeSysfs(t)
+	tests := []struct {
+		option Option
+		port   string
+	}{
+		{WithSerialNumber("75830303934351F0A1B2"), "/dev/ttyACM0"},
+		{WithFirmware("StandardFirmata.ino"), "/dev/ttyUSB0"},
+	}
+	for _, tt := range tests {
+		arduino, err := New("test", tt.option, WithDiscovery(Discovery{SysfsRoot: root, DevDir: "/dev"}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		arduino.openSP = func(ctx context.Context, port string) (io.ReadWriteCloser, error) {
+			if port == tt.port {
+				return sim.NewUno(), nil
+			}
+			return nil, errors.New("no such device")
+		}
+		if err := arduino.Connect(); err != nil {
+			t.Fatal(err)
+		}
+		arduino.Disconnect()
+		if port := arduino.Port(); port != tt.port {
+			t.Errorf("Port() = %q, want %q", port, tt.port)
+		}
+	}
+
+	arduino, err := New("test", WithSerialNumber("unknown"), WithDiscovery(Discovery{SysfsRoot: root, DevDir: "/dev"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := arduino.Connect(); !errors.Is(err, ErrNoBoard) {
+		t.Errorf("Connect() = %v, want ErrNoBoard", err)
+	}
+}
+
+func TestNewDefaultDiscovery(t *testing.T) {
+	arduino, err := New("test", WithSerialNumber("abc"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := arduino.discovery
+	if d.SysfsRoot != DefaultDiscovery.SysfsRoot || d.DevDir != DefaultDiscovery.DevDir || d.ProbeTimeout != DefaultDiscovery.ProbeTimeout {
+		t.Errorf("discovery = %+v, want DefaultDiscovery", d)
+	}
+}
+
+func TestFindPortProbesOnce(t *testing.T) {
+	root := fakeSysfs(t)
+	d := Discovery{SysfsRoot: root, DevDir: "/dev", ProbeTimeout: time.Second}
+	uno, err := New("uno", WithSerialNumber("75830303934351F0A1B2"), WithDiscovery(d))
+	if err != nil {
+		t.Fatal(err)
+	}
+	uno.openSP = func(ctx context.Context, port string) (io.ReadWriteCloser, error) {
+		return sim.NewUno(), nil
+	}
+	if err := uno.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer uno.Disconnect()
+
+	opened := map[string]int{}
+	arduino, err := New("test", WithFirmware("StandardFirmata.ino"), WithDiscovery(d))
+	if err != nil {
+		t.Fatal(err)
+	}
+	arduino.openSP = func(ctx context.Context, port string) (io.ReadWriteCloser, error) {
+		opened[port]++
+		if port == "/dev/ttyAMA0" {
+			return nil, errors.New("no such device")
+		}
+		return sim.NewUno(), nil
+	}
+	// The Uno holds ttyACM0, ttyUSB0 is probed then opened
+	want := map[string]int{"/dev/ttyAMA0": 1, "/dev/ttyUSB0": 2}
+	for i := 0; i < 2; i++ {
+		if err := arduino.Connect(); err != nil {
+			t.Fatal(err)
+		}
+		arduino.Disconnect()
+		if port := arduino.Port(); port != "/dev/ttyUSB0" {
+			t.Errorf("connect %d: Port() = %q, want /dev/ttyUSB0", i+1, port)
+		}
+		for port, n := range want {
+			if opened[port] != n {
+				t.Errorf("connect %d: %s opened %d times, want %d", i+1, port, opened[port], n)
+			}
+		}
+		// Found by serial number from now on
+		want = map[string]int{"/dev/ttyAMA0": 1, "/dev/ttyUSB0": 3}
+	}
+	if opened["/dev/ttyACM0"] != 0 {
+		t.Errorf("ttyACM0 held by the Uno was opened %d times", opened["/dev/ttyACM0"])
+	}
+}
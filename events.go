@@ -0,0 +1,109 @@
+package goduino
+
+import "github.com/argandas/goduino/firmata"
+
+// pinListener is a callback registered for a single pin
+type pinListener struct {
+	id        int
+	analog    bool
+	threshold int
+	last      int
+	fn        func(old, new int)
+}
+
+// OnDigitalChange calls fn with the previous and the new value every time
+// the board reports a change on digital pin. The pin must be configured as
+// an input for the board to report it. The returned function removes the
+// callback.
+func (ino *Goduino) OnDigitalChange(pin int, fn func(old, new int)) (unsubscribe func()) {
+	return ino.addListener(pin, &pinListener{fn: fn})
+}
+
+// OnAnalogChange calls fn with the new value every time the reading of
+// analog pin moves by at least threshold from the last value passed to fn.
+// The returned function removes the callback.
+func (ino *Goduino) OnAnalogChange(pin, threshold int, fn func(value int)) (unsubscribe func()) {
+	l := &pinListener{
+		analog:    true,
+		threshold: threshold,
+		fn:        func(old, new int) { fn(new) },
+	}
+	if p, err := ino.A(pin); err == nil {
+		if info, ok := ino.board.Pin(p); ok {
+			l.last = info.Value
+		}
+	}
+	return ino.addListener(pin, l)
+}
+
+// addListener registers l for a digital pin or, if l.analog is set, for an
+// analog channel.
+func (ino *Goduino) addListener(pin int, l *pinListener) func() {
+	ino.mu.Lock()
+	defer ino.mu.Unlock()
+	ino.nextID++
+	l.id = ino.nextID
+	listeners := ino.listeners
+	if l.analog {
+		listeners = ino.analogListeners
+	}
+	listeners[pin] = append(listeners[pin], l)
+	return func() { ino.removeListener(listeners, pin, l.id) }
+}
+
+func (ino *Goduino) removeListener(listeners map[int][]*pinListener, pin, id int) {
+	ino.mu.Lock()
+	defer ino.mu.Unlock()
+	list := listeners[pin]
+	for i, l := range list {
+		if l.id == id {
+			listeners[pin] = append(list[:i:i], list[i+1:]...)
+			break
+		}
+	}
+	if len(listeners[pin]) == 0 {
+		delete(listeners, pin)
+	}
+}
+
+// pinChanged dispatches a value change reported by the board to the
+// callbacks registered for pin, or for its analog channel when the pin is
+// in analog mode.
+func (ino *Goduino) pinChanged(pin, old, value int) {
+	type call struct {
+		fn       func(old, new int)
+		old, new int
+	}
+	calls := []call{}
+	p, _ := ino.board.Pin(pin)
+	ino.mu.Lock()
+	if p.Mode != Analog {
+		for _, l := range ino.listeners[pin] {
+			calls = append(calls, call{l.fn, old, value})
+		}
+	} else {
+		for _, l := range ino.analogListeners[p.AnalogChannel] {
+			if d := value - l.last; d >= l.threshold || -d >= l.threshold {
+				calls = append(calls, call{l.fn, l.last, value})
+				l.last = value
+			}
+		}
+	}
+	ino.mu.Unlock()
+	// Run callbacks without holding the lock so they can unsubscribe
+	for _, c := range calls {
+		c.fn(c.old, c.new)
+	}
+}
+
+// Events returns the stream of messages decoded from the board, see
+// firmata.Firmata.Events for the buffering policy.
+func (ino *Goduino) Events() <-chan firmata.Event {
+	return ino.board.Events()
+}
+
+// Dropped returns the number of events discarded because the Events buffer
+// was full.
+func (ino *Goduino) Dropped() uint64 {
+	return ino.board.Dropped()
+}
@@ -0,0 +1,63 @@
+package goduino
+
+import (
+	"testing"
+	"time"
+
+	"github.com/argandas/goduino/firmata/sim"
+)
+
+func TestOnDigitalChange(t *testing.T) {
+	board := sim.NewUno()
+	arduino := connectSim(t, board)
+	changes := make(chan [2]int, 4)
+	unsubscribe := arduino.OnDigitalChange(7, func(old, new int) { changes <- [2]int{old, new} })
+	if err := arduino.PinMode(7, Input); err != nil {
+		t.Fatal(err)
+	}
+	board.SetDigital(7, 1)
+	select {
+	case change := <-changes:
+		if change != [2]int{0, 1} {
+			t.Errorf("change = %v, want [0 1]", change)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("pin 7 change not reported")
+	}
+
+	unsubscribe()
+	board.SetDigital(7, 0)
+	syncPin(t, arduino, 7)
+	select {
+	case change := <-changes:
+		t.Errorf("change %v reported after unsubscribe", change)
+	default:
+	}
+}
+
+func TestOnAnalogChange(t *testing.T) {
+	board := sim.NewUno()
+	arduino := connectSim(t, board)
+	values := make(chan int, 4)
+	arduino.OnAnalogChange(1, 10, func(value int) { values <- value })
+	if err := arduino.PinMode(1, Analog); err != nil {
+		t.Fatal(err)
+	}
+	// Below the threshold
+	board.SetAnalog(1, 5)
+	board.SetAnalog(1, 20)
+	select {
+	case value := <-values:
+		if value != 20 {
+			t.Errorf("value = %d, want 20", value)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("A1 change not reported")
+	}
+	syncPin(t, arduino, 15)
+	select {
+	case value := <-values:
+		t.Errorf("extra value %d reported", value)
+	default:
+	}
+}
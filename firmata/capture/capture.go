@@ -0,0 +1,247 @@
+// Package capture records the frames exchanged with a Firmata board and
+// plays them back, so traffic captured on real hardware can be reproduced
+// without it.
+//
+// A Recorder is a firmata.Tracer, pass it to firmata.WithTracer or
+// goduino.WithTracer to write every frame to a file:
+//
+//	rec, err := capture.Create("board.jsonl", capture.JSONLines)
+//	arduino, err := goduino.New("myArduino", goduino.WithPort("/dev/ttyACM0"),
+//		goduino.WithTracer(rec))
+//	...
+//	rec.Close()
+//
+// Load reads the capture back and NewReplay turns it into a connection that
+// answers like the board did.
+package capture
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/argandas/goduino/firmata"
+)
+
+// Errors
+var ErrFormat = errors.New("capture: invalid capture data")
+
+// Format is the encoding of a capture file
+type Format int
+
+// Capture formats. JSONLines writes one JSON object per frame, Binary
+// writes a header followed by fixed size frame headers and the raw bytes.
+const (
+	JSONLines Format = iota
+	Binary
+)
+
+// binaryMagic starts every Binary capture
+const binaryMagic = "FMTCAP\x01"
+
+// maxFrame is the largest frame a Binary capture can hold
+const maxFrame = 0xFFFF
+
+// Frame is a frame exchanged with the board
+type Frame struct {
+	Time time.Time
+	Dir  firmata.Direction
+	Data []byte
+}
+
+// jsonFrame is the JSONLines encoding of a Frame, the data is spelled in hex
+// so captures stay readable
+type jsonFrame struct {
+	Time time.Time `json:"time"`
+	Dir  string    `json:"dir"`
+	Data string    `json:"data"`
+}
+
+// Recorder writes the frames it traces to w. It implements firmata.Tracer.
+type Recorder struct {
+	mu     sync.Mutex
+	w      *bufio.Writer
+	closer io.Closer
+	format Format
+	err    error
+}
+
+// NewRecorder returns a Recorder writing frames to w in format.
+func NewRecorder(w io.Writer, format Format) *Recorder {
+	r := &Recorder{w: bufio.NewWriter(w), format: format}
+	if format == Binary {
+		_, r.err = r.w.WriteString(binaryMagic)
+	}
+	return r
+}
+
+// Create creates the file at path and returns a Recorder writing to it.
+func Create(path string, format Format) (*Recorder, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	r := NewRecorder(f, format)
+	r.closer = f
+	return r, nil
+}
+
+// TraceFrame records frame. Write errors are kept and reported by Err and
+// Close, later frames are dropped.
+func (r *Recorder) TraceFrame(dir firmata.Direction, frame []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err != nil {
+		return
+	}
+	r.err = r.write(Frame{Time: time.Now(), Dir: dir, Data: frame})
+	if r.err == nil {
+		// Flush every frame so a crash keeps the capture up to it
+		r.err = r.w.Flush()
+	}
+}
+
+func (r *Recorder) write(f Frame) error {
+	switch r.format {
+	case JSONLines:
+		line, err := json.Marshal(jsonFrame{Time: f.Time, Dir: f.Dir.String(), Data: hexString(f.Data)})
+		if err != nil {
+			return err
+		}
+		_, err = r.w.Write(append(line, '\n'))
+		return err
+	case Binary:
+		if len(f.Data) > maxFrame {
+			return fmt.Errorf("capture: frame of %d bytes is too long", len(f.Data))
+		}
+		var hdr [11]byte
+		hdr[0] = byte(f.Dir)
+		binary.BigEndian.PutUint64(hdr[1:], uint64(f.Time.UnixNano()))
+		binary.BigEndian.PutUint16(hdr[9:], uint16(len(f.Data)))
+		if _, err := r.w.Write(hdr[:]); err != nil {
+			return err
+		}
+		_, err := r.w.Write(f.Data)
+		return err
+	}
+	return fmt.Errorf("capture: unknown format %d", r.format)
+}
+
+// Err returns the first error met while writing the capture.
+func (r *Recorder) Err() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.err
+}
+
+// Close flushes the capture and closes the file opened by Create.
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err == nil {
+		r.err = r.w.Flush()
+	}
+	err := r.err
+	if r.closer != nil {
+		if cerr := r.closer.Close(); err == nil {
+			err = cerr
+		}
+		r.closer = nil
+	}
+	return err
+}
+
+// Read decodes a capture written in either format.
+func Read(rd io.Reader) ([]Frame, error) {
+	br := bufio.NewReader(rd)
+	magic, err := br.Peek(len(binaryMagic))
+	if err == nil && string(magic) == binaryMagic {
+		br.Discard(len(binaryMagic))
+		return readBinary(br)
+	}
+	return readJSON(br)
+}
+
+// Load reads the capture file at path.
+func Load(path string) ([]Frame, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Read(f)
+}
+
+func readBinary(r io.Reader) ([]Frame, error) {
+	frames := []Frame{}
+	for {
+		var hdr [11]byte
+		if _, err := io.ReadFull(r, hdr[:]); err != nil {
+			if err == io.EOF {
+				return frames, nil
+			}
+			return frames, fmt.Errorf("%w: frame %d: %v", ErrFormat, len(frames), err)
+		}
+		if hdr[0] > byte(firmata.Rx) {
+			return frames, fmt.Errorf("%w: frame %d: direction %d", ErrFormat, len(frames), hdr[0])
+		}
+		data := make([]byte, binary.BigEndian.Uint16(hdr[9:]))
+		if _, err := io.ReadFull(r, data); err != nil {
+			return frames, fmt.Errorf("%w: frame %d: %v", ErrFormat, len(frames), err)
+		}
+		frames = append(frames, Frame{
+			Time: time.Unix(0, int64(binary.BigEndian.Uint64(hdr[1:]))),
+			Dir:  firmata.Direction(hdr[0]),
+			Data: data,
+		})
+	}
+}
+
+func readJSON(r io.Reader) ([]Frame, error) {
+	frames := []Frame{}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 4*maxFrame)
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		var jf jsonFrame
+		if err := json.Unmarshal(scanner.Bytes(), &jf); err != nil {
+			return frames, fmt.Errorf("%w: line %d: %v", ErrFormat, line, err)
+		}
+		f := Frame{Time: jf.Time}
+		switch jf.Dir {
+		case firmata.Tx.String():
+			f.Dir = firmata.Tx
+		case firmata.Rx.String():
+			f.Dir = firmata.Rx
+		default:
+			return frames, fmt.Errorf("%w: line %d: direction %q", ErrFormat, line, jf.Dir)
+		}
+		data, err := hex.DecodeString(strings.Replace(jf.Data, " ", "", -1))
+		if err != nil {
+			return frames, fmt.Errorf("%w: line %d: %v", ErrFormat, line, err)
+		}
+		f.Data = data
+		frames = append(frames, f)
+	}
+	return frames, scanner.Err()
+}
+
+// hexString spells data as space separated hex bytes.
+func hexString(data []byte) string {
+	s := make([]string, len(data))
+	for i, b := range data {
+		s[i] = fmt.Sprintf("%02X", b)
+	}
+	return strings.Join(s, " ")
+}
@@ -0,0 +1,64 @@
+package capture
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/argandas/goduino/firmata"
+	"github.com/argandas/goduino/firmata/sim"
+)
+
+func TestFileRoundTrip(t *testing.T) {
+	for _, format := range []Format{JSONLines, Binary} {
+		path := filepath.Join(t.TempDir(), "board.cap")
+		rec, err := Create(path, format)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f := firmata.New(firmata.WithTracer(rec))
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		if err := f.ConnectContext(ctx, sim.NewUno()); err != nil {
+			t.Fatal(err)
+		}
+		if err := f.DigitalWrite(13, 1); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := f.QueryPinState(ctx, 13); err != nil {
+			t.Fatal(err)
+		}
+		cancel()
+		f.Disconnect()
+		if err := rec.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		frames, err := Load(path)
+		if err != nil {
+			t.Fatalf("format %d: %v", format, err)
+		}
+		if len(frames) == 0 || frames[0].Dir != firmata.Tx || frames[0].Time.IsZero() {
+			t.Fatalf("format %d: loaded %+v", format, frames)
+		}
+
+		// The capture answers a new client like the board did
+		replay := NewReplay(frames)
+		f = firmata.New()
+		ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		if err := f.ConnectContext(ctx, replay); err != nil {
+			t.Fatalf("format %d: %v", format, err)
+		}
+		if err := f.DigitalWrite(13, 1); err != nil {
+			t.Fatal(err)
+		}
+		if state, err := f.QueryPinState(ctx, 13); err != nil || state.State != 1 {
+			t.Fatalf("format %d: QueryPinState() = %+v, %v", format, state, err)
+		}
+		if err := replay.Wait(ctx); err != nil {
+			t.Errorf("format %d: Wait() = %v", format, err)
+		}
+		f.Disconnect()
+	}
+}
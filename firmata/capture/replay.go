@@ -0,0 +1,176 @@
+package capture
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/argandas/goduino/firmata"
+)
+
+// Errors
+var ErrClosed = errors.New("capture: replay is closed")
+
+// MismatchError reports host bytes that differ from the frame the capture
+// expected the host to send.
+type MismatchError struct {
+	Index int    // index of the expected frame in the capture
+	Want  []byte // expected frame, nil once the capture has ended
+	Got   []byte // bytes the host sent instead
+}
+
+func (e *MismatchError) Error() string {
+	if e.Want == nil {
+		return fmt.Sprintf("capture: unexpected frame %s after the end of the capture", hexString(e.Got))
+	}
+	return fmt.Sprintf("capture: frame %d: host sent %s, want %s", e.Index, hexString(e.Got), hexString(e.Want))
+}
+
+// Replay plays a capture back as the board. It implements
+// io.ReadWriteCloser: the host reads the frames the board sent and every
+// frame the host writes is checked against the capture. Received frames are
+// released as soon as the host frames recorded before them have been
+// matched.
+type Replay struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	frames []Frame
+	next   int    // index of the next frame to play
+	out    []byte // board frames waiting to be read
+	in     []byte // host bytes of the frame being matched
+	err    error
+	closed bool
+}
+
+// NewReplay returns a Replay playing frames.
+func NewReplay(frames []Frame) *Replay {
+	r := &Replay{frames: frames}
+	r.cond = sync.NewCond(&r.mu)
+	r.advance()
+	return r
+}
+
+// Read reads frames sent by the board, it blocks until data is available or
+// the replay is closed. Once the capture is over reads block until Close.
+func (r *Replay) Read(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for len(r.out) == 0 && !r.closed {
+		r.cond.Wait()
+	}
+	if r.closed {
+		return 0, io.EOF
+	}
+	n := copy(p, r.out)
+	r.out = r.out[n:]
+	return n, nil
+}
+
+// Write checks host bytes against the capture. Frames may be split across
+// writes. The first mismatch is returned as a *MismatchError by this and
+// every later Write.
+func (r *Replay) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return 0, ErrClosed
+	}
+	if r.err != nil {
+		return 0, r.err
+	}
+	r.in = append(r.in, p...)
+	for len(r.in) > 0 {
+		if r.next >= len(r.frames) {
+			return 0, r.fail(&MismatchError{Index: r.next, Got: r.in})
+		}
+		want := r.frames[r.next].Data
+		n := len(r.in)
+		if n > len(want) {
+			n = len(want)
+		}
+		if !bytes.Equal(r.in[:n], want[:n]) {
+			return 0, r.fail(&MismatchError{Index: r.next, Want: want, Got: r.in})
+		}
+		if n < len(want) {
+			// Wait for the rest of the frame
+			break
+		}
+		r.in = r.in[n:]
+		r.next++
+		r.advance()
+	}
+	return len(p), nil
+}
+
+// Close closes the replay, pending and future reads return io.EOF.
+func (r *Replay) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.closed = true
+	r.cond.Broadcast()
+	return nil
+}
+
+// Err returns the first mismatch between the host and the capture.
+func (r *Replay) Err() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.err
+}
+
+// Remaining returns the number of frames of the capture not played yet.
+func (r *Replay) Remaining() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.frames) - r.next
+}
+
+// Wait blocks until the host has sent every frame of the capture, a frame
+// didn't match or ctx is done.
+func (r *Replay) Wait(ctx context.Context) error {
+	// Wake the wait below when ctx is done
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			r.mu.Lock()
+			r.cond.Broadcast()
+			r.mu.Unlock()
+		case <-done:
+		}
+	}()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for r.next < len(r.frames) && r.err == nil && !r.closed && ctx.Err() == nil {
+		r.cond.Wait()
+	}
+	switch {
+	case r.err != nil:
+		return r.err
+	case r.next < len(r.frames) && ctx.Err() != nil:
+		return ctx.Err()
+	case r.next < len(r.frames):
+		return ErrClosed
+	}
+	return nil
+}
+
+// advance queues the board frames up to the next host frame.
+func (r *Replay) advance() {
+	for r.next < len(r.frames) && r.frames[r.next].Dir == firmata.Rx {
+		r.out = append(r.out, r.frames[r.next].Data...)
+		r.next++
+	}
+	r.cond.Broadcast()
+}
+
+func (r *Replay) fail(err *MismatchError) error {
+	err.Got = append([]byte{}, err.Got...)
+	r.err = err
+	r.cond.Broadcast()
+	return err
+}
@@ -0,0 +1,52 @@
+package capture
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/argandas/goduino/firmata"
+)
+
+func TestReplayWait(t *testing.T) {
+	r := NewReplay([]Frame{
+		{Dir: firmata.Tx, Data: []byte{0xF9}},
+		{Dir: firmata.Rx, Data: []byte{0xF9, 2, 5}},
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := r.Wait(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Wait() = %v, want DeadlineExceeded", err)
+	}
+	if _, err := r.Write([]byte{0xF9}); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Wait(context.Background()); err != nil {
+		t.Fatalf("Wait() = %v", err)
+	}
+	if _, err := r.Write([]byte{0xFF}); err == nil {
+		t.Fatal("Write after the end of the capture succeeded")
+	}
+	var mismatch *MismatchError
+	if err := r.Wait(context.Background()); !errors.As(err, &mismatch) || mismatch.Want != nil {
+		t.Fatalf("Wait() = %v, want a MismatchError past the end", err)
+	}
+}
+
+func TestReplayWaitCancel(t *testing.T) {
+	r := NewReplay([]Frame{{Dir: firmata.Tx, Data: []byte{0xF9}}})
+	ctx, cancel := context.WithCancel(context.Background())
+	errc := make(chan error, 1)
+	go func() { errc <- r.Wait(ctx) }()
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+	select {
+	case err := <-errc:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("Wait() = %v, want Canceled", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Wait() didn't return when its context was cancelled")
+	}
+}
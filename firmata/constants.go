@@ -5,24 +5,42 @@ import "fmt"
 type FirmataCommand byte
 type SysExCommand byte
 type SerialPort byte
+type SPISubCommand byte
+type SerialSubCommand byte
 
 // Pin Modes
 const (
-	Input  = 0x00
-	Output = 0x01
-	Analog = 0x02
-	Pwm    = 0x03
-	Servo  = 0x04
+	Input   = 0x00
+	Output  = 0x01
+	Analog  = 0x02
+	Pwm     = 0x03
+	Servo   = 0x04
+	Shift   = 0x05
+	I2C     = 0x06
+	OneWire = 0x07
+	Stepper = 0x08
+	Encoder = 0x09
+	Uart    = 0x0A
+	Pullup  = 0x0B
+	Ignore  = 0x7F // pin is excluded from Firmata control
 
-	// SPIConfig SPISubCommand = 0x10
-	// SPIComm   SPISubCommand = 0x20
+	SPI_BEGIN         SPISubCommand = 0x00
+	SPI_DEVICE_CONFIG SPISubCommand = 0x01
+	SPI_TRANSFER      SPISubCommand = 0x02
+	SPI_WRITE         SPISubCommand = 0x03
+	SPI_READ          SPISubCommand = 0x04
+	SPI_REPLY         SPISubCommand = 0x05
+	SPI_END           SPISubCommand = 0x06
 
 	SPI_MODE0 = 0x00
 	SPI_MODE1 = 0x04
 	SPI_MODE2 = 0x08
 	SPI_MODE3 = 0x0C
 
-	SoftSerial  SerialPort = 0x00
+	SPI_LSBFIRST = 0x00
+	SPI_MSBFIRST = 0x01
+
+	SoftSerial  SerialPort = 0x08 // SW_SERIAL0, 0x00 is the port Firmata runs on
 	HardSerial1 SerialPort = 0x01
 	HardSerial2 SerialPort = 0x02
 	HardSerial3 SerialPort = 0x03
@@ -32,10 +50,16 @@ const (
 	I2CModeContinuousRead byte = 0x02
 	I2CModeStopReading    byte = 0x03
 
-	// SerialConfig SerialSubCommand = 0x10
-	// SerialComm   SerialSubCommand = 0x20
-	// SerialFlush  SerialSubCommand = 0x30
-	// SerialClose  SerialSubCommand = 0x40
+	SerialConfig SerialSubCommand = 0x10
+	SerialWrite  SerialSubCommand = 0x20
+	SerialRead   SerialSubCommand = 0x30
+	SerialReply  SerialSubCommand = 0x40
+	SerialClose  SerialSubCommand = 0x50
+	SerialFlush  SerialSubCommand = 0x60
+	SerialListen SerialSubCommand = 0x70
+
+	SerialReadContinuous byte = 0x00
+	SerialStopReading    byte = 0x01
 )
 
 // Firmata commands
@@ -58,12 +82,14 @@ const (
 // SysEx Commands
 const (
 	Serial                SysExCommand = 0x60
+	SysExSPI              SysExCommand = 0x68 // SPI_DATA of the SPI proposal
 	AnalogMappingQuery    SysExCommand = 0x69
 	AnalogMappingResponse SysExCommand = 0x6A
 	CapabilityQuery       SysExCommand = 0x6B
 	CapabilityResponse    SysExCommand = 0x6C
 	PinStateQuery         SysExCommand = 0x6D
 	PinStateResponse      SysExCommand = 0x6E
+	ExtendedAnalog        SysExCommand = 0x6F // analog write to any pin, with values wider than 14 bits
 	ServoConfig           SysExCommand = 0x70
 	StringData            SysExCommand = 0x71
 	ShiftData             SysExCommand = 0x75 // a bitstream to/from a shift register
@@ -74,7 +100,6 @@ const (
 	SamplingInterval      SysExCommand = 0x7A // set the poll rate of the main loop
 	SysExNonRealtime      SysExCommand = 0x7E // MIDI Reserved for non-realtime messages
 	SysExRealtime         SysExCommand = 0x7F // MIDI Reserved for realtime messages
-	SysExSPI              SysExCommand = 0x80
 )
 
 func (c FirmataCommand) String() string {
@@ -115,8 +140,8 @@ func (c SysExCommand) String() string {
 		return fmt.Sprintf("I2CReply (0x%x)", uint8(c))
 	case c == I2CConfig:
 		return fmt.Sprintf("I2CConfig (0x%x)", uint8(c))
-	// case c == ExtendedAnalog:
-	//	return fmt.Sprintf("ExtendedAnalog (0x%x)", uint8(c))
+	case c == ExtendedAnalog:
+		return fmt.Sprintf("ExtendedAnalog (0x%x)", uint8(c))
 	case c == PinStateQuery:
 		return fmt.Sprintf("PinStateQuery (0x%x)", uint8(c))
 	case c == PinStateResponse:
@@ -0,0 +1,108 @@
+package firmata
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// EventBufferSize is the number of events Events can hold before new ones
+// are dropped.
+const EventBufferSize = 256
+
+// Event is a message decoded by the process loop. It is one of
+// VersionEvent, FirmwareEvent, DigitalEvent, AnalogEvent, I2cReplyEvent,
+// SPIReplyEvent, SerialEvent, PinStateEvent, StringEvent or ErrorEvent.
+type Event interface {
+	isEvent()
+}
+
+// VersionEvent reports the protocol version of the board
+type VersionEvent struct {
+	Major int
+	Minor int
+}
+
+// FirmwareEvent reports the name and version of the firmware
+type FirmwareEvent struct {
+	Name  string
+	Major int
+	Minor int
+}
+
+// DigitalEvent reports the value of the 8 pins of a digital port
+type DigitalEvent struct {
+	Port  int
+	Value int
+}
+
+// AnalogEvent reports the value read on an analog channel, along with the
+// sampling interval in effect when it was read
+type AnalogEvent struct {
+	Channel  int
+	Pin      int
+	Value    int
+	Interval time.Duration
+}
+
+// I2cReplyEvent carries the data returned by an I2C read
+type I2cReplyEvent struct {
+	I2cReply
+}
+
+// SPIReplyEvent carries the data returned by an SPI transfer or read
+type SPIReplyEvent struct {
+	SPIReply
+}
+
+// SerialEvent carries data received on a serial port of the board
+type SerialEvent struct {
+	SerialData
+}
+
+// PinStateEvent reports the mode and state of a pin
+type PinStateEvent struct {
+	PinState
+}
+
+// StringEvent carries a string sent by the board
+type StringEvent struct {
+	Data string
+}
+
+// ErrorEvent reports a protocol or connection error
+type ErrorEvent struct {
+	Err error
+}
+
+func (VersionEvent) isEvent()  {}
+func (FirmwareEvent) isEvent() {}
+func (DigitalEvent) isEvent()  {}
+func (AnalogEvent) isEvent()   {}
+func (I2cReplyEvent) isEvent() {}
+func (SPIReplyEvent) isEvent() {}
+func (SerialEvent) isEvent()   {}
+func (PinStateEvent) isEvent() {}
+func (StringEvent) isEvent()   {}
+func (ErrorEvent) isEvent()    {}
+
+// Events returns the stream of decoded board messages. The channel holds up
+// to EventBufferSize events; the process loop never blocks on it, events that
+// don't fit are dropped and counted by Dropped.
+func (f *Firmata) Events() <-chan Event {
+	return f.events
+}
+
+// Dropped returns the number of events discarded because the Events buffer
+// was full.
+func (f *Firmata) Dropped() uint64 {
+	return atomic.LoadUint64(&f.dropped)
+}
+
+// emit queues e on the event stream without blocking.
+func (f *Firmata) emit(e Event) {
+	select {
+	case f.events <- e:
+	default:
+		atomic.AddUint64(&f.dropped, 1)
+	}
+}
@@ -2,35 +2,75 @@ package firmata
 
 import (
 	"bufio"
+	"context"
 	"errors"
 	"fmt"
 	"io"
-	"log"
 	"math"
-	"os"
+	"sync"
 	"time"
 )
 
 // Errors
-var ErrConnected = errors.New("client is already connected")
+var (
+	ErrConnected    = errors.New("client is already connected")
+	ErrNotConnected = errors.New("client is not connected")
+	ErrEmptySysEx   = errors.New("empty sysex message")
+	ErrTimeout      = errors.New("timed out waiting for the board")
+	ErrInterval     = errors.New("sampling interval out of range")
+	ErrInvalidPin   = errors.New("invalid pin number")
+)
+
+// Sampling interval limits, StandardFirmata reports every 19ms until told
+// otherwise.
+const (
+	DefaultSamplingInterval = 19 * time.Millisecond
+	MinSamplingInterval     = 1 * time.Millisecond
+	MaxSamplingInterval     = 0x3FFF * time.Millisecond
+)
 
-// Firmata represents a client connection to a firmata board
+// Firmata represents a client connection to a firmata board. It is safe for
+// concurrent use, the pin cache is guarded by mu and writes to the
+// connection are serialized by writeMu.
 type Firmata struct {
+	dropped           uint64 // first for 64-bit alignment of atomic ops
+	mu                sync.RWMutex
+	writeMu           sync.Mutex
 	pins              []Pin
-	FirmwareName      string
-	ProtocolVersion   string
+	firmwareName      string
+	protocolVersion   string
 	connected         bool
 	connection        io.ReadWriteCloser
 	analogPins        []int
+	samplingInterval  time.Duration
 	ready             bool
 	analogMappingDone bool
 	capabilityDone    bool
-	logger            *log.Logger
-}
+	onPinChange       PinChangeFunc
+	onDisconnect      func(error)
+	closing           bool
+	session           int
+	events            chan Event
+	pendingMu         sync.Mutex
+	i2cWaiters        []*i2cWaiter
+	i2cSubscribers    []*i2cSubscriber
+	spiWaiters        []*spiWaiter
+	serialHandlers    map[SerialPort]func([]byte)
+	pinStateWaiters   []*pinStateWaiter
+	handshake         HandshakeConfig
+	stages            chan HandshakeStage
+	handshakeErr      chan error
+	logger            Logger
+	tracer            Tracer
+}
+
+// PinChangeFunc is called by the process loop when a digital or analog
+// report changes the value of pin.
+type PinChangeFunc func(pin, old, value int)
 
 // Pin represents a pin on the firmata board
 type Pin struct {
-	SupportedModes []int
+	SupportedModes map[int]int // supported mode -> resolution in bits
 	Mode           int
 	Value          int
 	State          int
@@ -44,16 +84,43 @@ type I2cReply struct {
 	Data     []byte
 }
 
-// New returns a new Firmata
-func New() *Firmata {
+// Option configures a Firmata created by New.
+type Option func(*Firmata)
+
+// WithLogger sends the diagnostic messages of the client to l.
+func WithLogger(l Logger) Option {
+	return func(f *Firmata) {
+		if l != nil {
+			f.logger = l
+		}
+	}
+}
+
+// WithTracer hands every frame exchanged with the board to t.
+func WithTracer(t Tracer) Option {
+	return func(f *Firmata) {
+		f.tracer = t
+	}
+}
+
+// New returns a new Firmata configured by opts, by default the client is
+// silent.
+func New(opts ...Option) *Firmata {
 	c := &Firmata{
-		ProtocolVersion: "",
-		FirmwareName:    "",
-		connection:      nil,
-		pins:            []Pin{},
-		analogPins:      []int{},
-		connected:       false,
-		logger:          log.New(os.Stdout, "[firmata] ", log.Ltime),
+		protocolVersion:  "",
+		firmwareName:     "",
+		connection:       nil,
+		pins:             []Pin{},
+		analogPins:       []int{},
+		samplingInterval: DefaultSamplingInterval,
+		connected:        false,
+		events:           make(chan Event, EventBufferSize),
+		serialHandlers:   map[SerialPort]func([]byte){},
+		handshake:        DefaultHandshake,
+		logger:           Discard,
+	}
+	for _, opt := range opts {
+		opt(c)
 	}
 
 	return c
@@ -61,55 +128,87 @@ func New() *Firmata {
 
 // Disconnect disconnects the Firmata
 func (f *Firmata) Disconnect() (err error) {
+	f.mu.Lock()
 	f.connected = false
-	return f.connection.Close()
+	f.closing = true
+	conn := f.connection
+	f.mu.Unlock()
+	if conn == nil {
+		// Never connected
+		return nil
+	}
+	return conn.Close()
+}
+
+// FirmwareName returns the firmware name reported by the last handshake.
+func (f *Firmata) FirmwareName() string {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.firmwareName
+}
+
+// ProtocolVersion returns the protocol version reported by the last
+// handshake.
+func (f *Firmata) ProtocolVersion() string {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.protocolVersion
 }
 
 // Connected returns the current connection state of the Firmata
 func (f *Firmata) Connected() bool {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
 	return f.connected
 }
 
-// Pins returns all available pins
+// Pins returns a snapshot of all available pins. The SupportedModes maps are
+// shared with the client and must not be modified.
 func (f *Firmata) Pins() []Pin {
-	return f.pins
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return append([]Pin{}, f.pins...)
 }
 
-// Connect connects to the Firmata given conn. It first resets the firmata board
-// then continuously polls the firmata board for new information when it's
-// available.
-func (f *Firmata) Connect(conn io.ReadWriteCloser) (err error) {
-	if f.connected {
-		return ErrConnected
+// Pin returns a snapshot of pin, false if the board has no such pin.
+func (f *Firmata) Pin(pin int) (Pin, bool) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	if pin < 0 || pin >= len(f.pins) {
+		return Pin{}, false
 	}
+	return f.pins[pin], true
+}
 
-	f.connection = conn
-
-	// Start threads
-	go f.process()
+// AnalogPins returns the pin number of each analog channel, as reported by
+// the analog mapping response. Channels the board doesn't have are -1.
+func (f *Firmata) AnalogPins() []int {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return append([]int{}, f.analogPins...)
+}
 
-	// Reset device
-	f.Reset()
+// OnPinChange sets the function called whenever an incoming report changes
+// the value of a pin. It runs on the process goroutine and must not block.
+func (f *Firmata) OnPinChange(fn PinChangeFunc) {
+	f.mu.Lock()
+	f.onPinChange = fn
+	f.mu.Unlock()
+}
 
-	// Wait for device to response
-	t := time.NewTicker(time.Second)
-	for !f.connected {
-		select {
-		case <-t.C:
-			// Do nothing
-		case <-time.After(time.Second * 15):
-			f.logger.Print("No response in 15 seconds. Resetting device")
-			f.Reset()
-		case <-time.After(time.Second * 30):
-			// Close connections
-			f.connection.Close()
-			return errors.New("Unable to initialize connection")
-		}
-	}
+// OnDisconnect sets the function called when the connection to the board
+// is lost, with the read error that revealed it. It is not called after
+// Disconnect. It runs on the process goroutine, which exits right after.
+func (f *Firmata) OnDisconnect(fn func(error)) {
+	f.mu.Lock()
+	f.onDisconnect = fn
+	f.mu.Unlock()
+}
 
-	// Firmata creation successful
-	f.logger.Print("Firmata ready to use")
-	return nil
+// Connect connects to the Firmata given conn, waiting for the handshake as
+// configured by SetHandshake. See ConnectContext.
+func (f *Firmata) Connect(conn io.ReadWriteCloser) error {
+	return f.ConnectContext(context.Background(), conn)
 }
 
 // Reset sends the SystemReset sysex code.
@@ -119,7 +218,13 @@ func (f *Firmata) Reset() error {
 
 // SetPinMode sets the pin to mode.
 func (f *Firmata) SetPinMode(pin int, mode int) error {
-	f.pins[byte(pin)].Mode = mode
+	f.mu.Lock()
+	if pin < 0 || pin >= len(f.pins) {
+		f.mu.Unlock()
+		return fmt.Errorf("%w: %d", ErrInvalidPin, pin)
+	}
+	f.pins[pin].Mode = mode
+	f.mu.Unlock()
 	return f.sendCommand([]byte{byte(PinMode), byte(pin), byte(mode)})
 }
 
@@ -127,35 +232,99 @@ func (f *Firmata) SetPinMode(pin int, mode int) error {
 func (f *Firmata) DigitalWrite(pin int, value int) error {
 	port := byte(math.Floor(float64(pin) / 8))
 	portValue := byte(0)
+	// Hold writeMu so concurrent writes to the same port go out in the
+	// order their values were combined
+	f.writeMu.Lock()
+	defer f.writeMu.Unlock()
+	f.mu.Lock()
+	if pin < 0 || pin >= len(f.pins) {
+		f.mu.Unlock()
+		return fmt.Errorf("%w: %d", ErrInvalidPin, pin)
+	}
 	f.pins[pin].Value = value
 	// Build command
 	for i := byte(0); i < 8; i++ {
-		if f.pins[8*port+i].Value != 0 {
+		if int(8*port+i) < len(f.pins) && f.pins[8*port+i].Value != 0 {
 			portValue = portValue | (1 << i)
 		}
 	}
-	return f.sendCommand([]byte{byte(DigitalMessage) | port, portValue & 0x7F, (portValue >> 7) & 0x7F})
+	f.mu.Unlock()
+	return f.writeLocked([]byte{byte(DigitalMessage) | port, portValue & 0x7F, (portValue >> 7) & 0x7F})
 }
 
-// ServoConfig sets the min and max pulse width for servo PWM range
-func (f *Firmata) ServoConfig(pin int, max int, min int) error {
+// ServoConfig sets the min and max pulse width, in microseconds, for servo
+// PWM range
+func (f *Firmata) ServoConfig(pin int, min int, max int) error {
 	ret := []byte{
 		byte(ServoConfig),
 		byte(pin),
-		byte(max & 0x7F),
-		byte((max >> 7) & 0x7F),
 		byte(min & 0x7F),
 		byte((min >> 7) & 0x7F),
+		byte(max & 0x7F),
+		byte((max >> 7) & 0x7F),
 	}
 	return f.writeSysex(ret)
 }
 
 // AnalogWrite writes value to pin.
+// Pins above 15 and values wider than 14 bits don't fit in an AnalogMessage
+// and are sent as an ExtendedAnalog sysex.
 func (f *Firmata) AnalogWrite(pin int, value int) error {
-	f.pins[pin].Value = value
+	f.mu.Lock()
+	if pin >= 0 && pin < len(f.pins) {
+		f.pins[pin].Value = value
+	}
+	f.mu.Unlock()
+	if pin > 0x0F || value > 0x3FFF {
+		return f.ExtendedAnalogWrite(pin, value)
+	}
 	return f.write([]byte{byte(AnalogMessage) | byte(pin), byte(value & 0x7F), byte((value >> 7) & 0x7F)})
 }
 
+// ExtendedAnalogWrite writes value to pin with an ExtendedAnalog sysex.
+func (f *Firmata) ExtendedAnalogWrite(pin int, value int) error {
+	ret := []byte{byte(ExtendedAnalog), byte(pin), byte(value & 0x7F), byte((value >> 7) & 0x7F)}
+	for value >>= 14; value > 0; value >>= 7 {
+		ret = append(ret, byte(value&0x7F))
+	}
+	return f.writeSysex(ret)
+}
+
+// Resolution returns the resolution in bits of pin in mode, as reported by
+// the capability response, or 0 if the pin doesn't support mode.
+func (f *Firmata) Resolution(pin int, mode int) int {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	if pin < 0 || pin >= len(f.pins) {
+		return 0
+	}
+	return f.pins[pin].SupportedModes[mode]
+}
+
+// SetSamplingInterval sets how often the board reports analog values and
+// continuous I2C reads. d is rounded down to whole milliseconds and must be
+// between MinSamplingInterval and MaxSamplingInterval.
+func (f *Firmata) SetSamplingInterval(d time.Duration) error {
+	if d < MinSamplingInterval || d > MaxSamplingInterval {
+		return fmt.Errorf("%w: %v", ErrInterval, d)
+	}
+	ms := int(d / time.Millisecond)
+	if err := f.writeSysex([]byte{byte(SamplingInterval), byte(ms & 0x7F), byte((ms >> 7) & 0x7F)}); err != nil {
+		return err
+	}
+	f.mu.Lock()
+	f.samplingInterval = time.Duration(ms) * time.Millisecond
+	f.mu.Unlock()
+	return nil
+}
+
+// SamplingInterval returns the sampling interval last set on the board.
+func (f *Firmata) SamplingInterval() time.Duration {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.samplingInterval
+}
+
 // FirmwareQuery sends the FirmwareQuery sysex code.
 func (f *Firmata) FirmwareQuery() error {
 	return f.writeSysex([]byte{byte(FirmwareQuery)})
@@ -195,24 +364,36 @@ func (f *Firmata) ReportAnalog(pin int, state int) error {
 
 // I2cRead reads numBytes from address once.
 func (f *Firmata) I2cRead(address int, numBytes int) error {
-	return f.writeSysex([]byte{byte(I2CRequest), byte(address), (I2CModeRead << 3),
-		byte(numBytes) & 0x7F, (byte(numBytes) >> 7) & 0x7F})
+	return f.I2cReadRequest(address, numBytes, I2CRequestOptions{})
+}
+
+// I2cReadRegister reads numBytes from register at address once. A negative
+// register reads from the register the device currently points to.
+func (f *Firmata) I2cReadRegister(address int, register int, numBytes int) error {
+	return f.I2cReadRequest(address, numBytes, registerOptions(register))
+}
+
+// I2cReadContinuous asks the board to read numBytes from register at address
+// on every sampling interval until I2cStopReading is sent. A negative
+// register reads from the register the device currently points to.
+func (f *Firmata) I2cReadContinuous(address int, register int, numBytes int) error {
+	return f.I2cContinuousReadRequest(address, numBytes, registerOptions(register))
+}
+
+// I2cStopReading stops a continuous read from address.
+func (f *Firmata) I2cStopReading(address int) error {
+	return f.i2cRequest(address, I2CModeStopReading, I2CRequestOptions{}, nil)
 }
 
 // I2cWrite writes data to address.
 func (f *Firmata) I2cWrite(address int, data []byte) error {
-	ret := []byte{byte(I2CRequest), byte(address), (I2CModeWrite << 3)}
-	for _, val := range data {
-		ret = append(ret, byte(val&0x7F))
-		ret = append(ret, byte((val>>7)&0x7F))
-	}
-	return f.writeSysex(ret)
+	return f.I2cWriteRequest(address, data, I2CRequestOptions{})
 }
 
 // I2cConfig configures the delay in which a register can be read from after it
 // has been written to.
 func (f *Firmata) I2cConfig(delay int) error {
-	return f.writeSysex([]byte{byte(I2CConfig), byte(delay & 0xFF), byte((delay >> 8) & 0xFF)})
+	return f.writeSysex([]byte{byte(I2CConfig), byte(delay & 0x7F), byte((delay >> 7) & 0x7F)})
 }
 
 func (f *Firmata) togglePinReporting(pin int, state int, mode byte) error {
@@ -232,55 +413,58 @@ func (f *Firmata) togglePinReporting(pin int, state int, mode byte) error {
 
 func (f *Firmata) writeSysex(data []byte) (err error) {
 	frame := append([]byte{byte(StartSysex)}, append(data, byte(EndSysex))...)
-	f.printSysExData("SysEx Tx", SysExCommand(frame[1]), frame)
 	return f.write(frame)
 }
 
 func (f *Firmata) write(data []byte) (err error) {
-	_, err = f.connection.Write(data[:])
+	f.writeMu.Lock()
+	defer f.writeMu.Unlock()
+	return f.writeLocked(data)
+}
+
+// writeLocked writes data to the connection, the caller holds writeMu.
+func (f *Firmata) writeLocked(data []byte) (err error) {
+	f.mu.RLock()
+	conn := f.connection
+	f.mu.RUnlock()
+	if conn == nil {
+		return ErrNotConnected
+	}
+	f.trace(Tx, data)
+	_, err = conn.Write(data)
 	return
 }
 
 func (f *Firmata) sendCommand(cmd []byte) error {
-	f.printByteArray("Command send", cmd)
-	_, err := f.connection.Write(cmd)
-	return err
+	return f.write(cmd)
 }
 
-func (f *Firmata) read(length int) (buf []byte, err error) {
-	i := 0
-	for length > 0 {
-		tmp := make([]byte, length)
-		if i, err = f.connection.Read(tmp); err != nil {
-			if err.Error() != "EOF" {
-				return
-			}
-			<-time.After(5 * time.Millisecond)
-		}
-		if i > 0 {
-			buf = append(buf, tmp...)
-			length = length - i
-		}
-	}
+func (f *Firmata) read(r io.Reader, length int) (buf []byte, err error) {
+	buf = make([]byte, length)
+	_, err = io.ReadFull(r, buf)
 	return
 }
 
 func (f *Firmata) process() {
+	f.mu.RLock()
+	session := f.session
 	r := bufio.NewReader(f.connection)
+	f.mu.RUnlock()
 	var init bool
 	for {
 		b, err := r.ReadByte()
 		if err != nil {
-			f.logger.Panic(err)
+			f.linkLost(session, err)
 			return
 		}
 		cmd := FirmataCommand(b)
-		f.logger.Printf("Incoming cmd %v", cmd)
+		f.logger.Debugf("Incoming cmd %v", cmd)
 
 		// First received byte must be ReportVersion command
 		if !init {
 			if cmd != ProtocolVersion {
-				f.logger.Printf("Discarding unexpected command byte %0d (not initialized)\n", b)
+				f.trace(Rx, []byte{b})
+				f.logger.Debugf("Discarding unexpected command byte %0d (not initialized)", b)
 				continue
 			} else {
 				init = true
@@ -289,175 +473,258 @@ func (f *Firmata) process() {
 
 		switch {
 		case ProtocolVersion == cmd:
-			buf, err := f.read(2)
+			buf, err := f.read(r, 2)
 			if err != nil {
-				f.logger.Panic(err)
+				f.linkLost(session, err)
 				return
 			}
-			f.ProtocolVersion = fmt.Sprintf("%v.%v", buf[0], buf[1])
-			f.logger.Printf("Protocol version: %s", f.ProtocolVersion)
+			f.trace(Rx, []byte{b, buf[0], buf[1]})
+			version := fmt.Sprintf("%v.%v", buf[0], buf[1])
+			f.mu.Lock()
+			f.protocolVersion = version
+			f.mu.Unlock()
+			f.logger.Debugf("Protocol version: %s", version)
+			f.emit(VersionEvent{Major: int(buf[0]), Minor: int(buf[1])})
+			f.stageDone(StageVersion)
 			f.FirmwareQuery()
 		case AnalogMessageRangeStart <= cmd && AnalogMessageRangeEnd >= cmd:
-			buf, err := f.read(2)
+			buf, err := f.read(r, 2)
 			if err != nil {
-				f.logger.Panic(err)
+				f.linkLost(session, err)
 				return
 			}
+			f.trace(Rx, []byte{b, buf[0], buf[1]})
 
 			value := uint(buf[0]) | uint(buf[1])<<7
-			pin := int((cmd & 0x0F))
+			channel := int((cmd & 0x0F))
 
-			if len(f.analogPins) > pin {
-				if len(f.pins) > f.analogPins[pin] {
-					f.pins[f.analogPins[pin]].Value = int(value)
-					f.logger.Printf("AnalogRead%v", pin)
-				}
+			f.mu.RLock()
+			pin := -1
+			if len(f.analogPins) > channel && f.analogPins[channel] < len(f.pins) {
+				pin = f.analogPins[channel]
+			}
+			interval := f.samplingInterval
+			f.mu.RUnlock()
+			if pin >= 0 {
+				f.setPinValue(pin, int(value))
+				f.logger.Debugf("AnalogRead%v", channel)
+				f.emit(AnalogEvent{Channel: channel, Pin: pin, Value: int(value), Interval: interval})
 			}
 		case DigitalMessageRangeStart <= cmd && DigitalMessageRangeEnd >= cmd:
-			buf, err := f.read(2)
+			buf, err := f.read(r, 2)
 			if err != nil {
-				f.logger.Panic(err)
+				f.linkLost(session, err)
 				return
 			}
+			f.trace(Rx, []byte{b, buf[0], buf[1]})
 			port := cmd & 0x0F
-			portValue := buf[1] | (buf[2] << 7)
+			portValue := buf[0] | (buf[1] << 7)
 			for i := 0; i < 8; i++ {
 				pinNumber := int((8*byte(port) + byte(i)))
-				if len(f.pins) > pinNumber {
-					if f.pins[pinNumber].Mode == Input {
-						f.pins[pinNumber].Value = int((portValue >> (byte(i) & 0x07)) & 0x01)
-						f.logger.Printf("DigitalRead%v", pinNumber)
+				if p, ok := f.Pin(pinNumber); ok {
+					if mode := p.Mode; mode == Input || mode == Pullup {
+						f.setPinValue(pinNumber, int((portValue>>(byte(i)&0x07))&0x01))
+						f.logger.Debugf("DigitalRead%v", pinNumber)
 					}
 				}
 			}
+			f.emit(DigitalEvent{Port: int(port), Value: int(portValue)})
 		case StartSysex == cmd:
 			sysExData, err := r.ReadSlice(byte(EndSysex))
 			if err != nil {
-				f.logger.Panic(err)
-				break
+				f.linkLost(session, err)
+				return
+			}
+			if f.tracer != nil {
+				f.trace(Rx, append([]byte{b}, sysExData...))
 			}
 			// Remove EndSysEx byte
 			f.parseSysEx(sysExData[:len(sysExData)-1])
+		default:
+			f.trace(Rx, []byte{b})
 		}
 	}
 }
 
-func (f *Firmata) parseSysEx(data []byte) {
+// linkLost handles a read error of the process loop started for session.
+// Errors caused by Disconnect are ignored, during the handshake the error
+// aborts Connect and afterwards the connection is marked lost and the
+// OnDisconnect callback runs.
+func (f *Firmata) linkLost(session int, err error) {
+	f.mu.Lock()
+	if f.closing || session != f.session {
+		// Connection was closed by Disconnect or replaced
+		f.mu.Unlock()
+		return
+	}
+	if !f.connected {
+		handshakeErr := f.handshakeErr
+		f.mu.Unlock()
+		select {
+		case handshakeErr <- err:
+		default:
+		}
+		return
+	}
+	f.connected = false
+	fn := f.onDisconnect
+	f.mu.Unlock()
+	f.logger.Errorf("Connection lost: %v", err)
+	f.emit(ErrorEvent{err})
+	if fn != nil {
+		fn(err)
+	}
+}
+
+// setPinValue stores a reported value and notifies the pin change callback.
+// The callback runs without the lock held so it can read the pins.
+func (f *Firmata) setPinValue(pin, value int) {
+	f.mu.Lock()
+	if pin >= len(f.pins) {
+		f.mu.Unlock()
+		return
+	}
+	old := f.pins[pin].Value
+	f.pins[pin].Value = value
+	fn := f.onPinChange
+	f.mu.Unlock()
+	if old != value && fn != nil {
+		fn(pin, old, value)
+	}
+}
 
-	// ino.printSysExData("SysEx Rx", cmd, data)
+func (f *Firmata) parseSysEx(data []byte) {
+	if len(data) == 0 {
+		f.emit(ErrorEvent{ErrEmptySysEx})
+		return
+	}
 
 	cmd := SysExCommand(data[0])
 	data = data[1:]
-	f.printSysExData("SysEx Rx", cmd, data)
+	f.logger.Debugf("Incoming sysex %v", cmd)
 
 	switch cmd {
 	case CapabilityResponse:
-		f.pins = []Pin{}
-		supportedModes := 0
-		n := 0
-		for _, val := range data[:(len(data) - 5)] {
-			if val == 127 {
-				modes := []int{}
-				for _, mode := range []int{Input, Output, Analog, Pwm, Servo} {
-					if (supportedModes & (1 << byte(mode))) != 0 {
-						modes = append(modes, mode)
-					}
-				}
-
-				f.pins = append(f.pins, Pin{SupportedModes: modes, Mode: Output})
-				supportedModes = 0
-				n = 0
+		// Each pin lists (mode, resolution) pairs and ends with 127
+		pins := []Pin{}
+		modes := map[int]int{}
+		for i := 0; i < len(data); i++ {
+			if data[i] == 127 {
+				pins = append(pins, Pin{SupportedModes: modes, Mode: Output})
+				modes = map[int]int{}
 				continue
 			}
-
-			if n == 0 {
-				supportedModes = supportedModes | (1 << val)
+			if i+1 >= len(data) {
+				break
 			}
-			n ^= 1
+			modes[int(data[i])] = int(data[i+1])
+			i++
 		}
-		f.logger.Printf("Total pins: %v\n", len(f.pins))
+		f.mu.Lock()
+		f.pins = pins
+		f.mu.Unlock()
+		f.logger.Debugf("Total pins: %v", len(pins))
+		f.stageDone(StageCapability)
 		f.AnalogMappingQuery()
 	case AnalogMappingResponse:
-		f.analogPins = []int{}
-		for index, val := range data[:len(f.pins)-1] {
+		// analogPins maps each channel to its pin, -1 for unused channels
+		analogPins := []int{}
+		f.mu.Lock()
+		for index, val := range data {
+			if index >= len(f.pins) {
+				break
+			}
 			f.pins[index].AnalogChannel = int(val)
-			if val != 127 {
-				f.analogPins = append(f.analogPins, index)
+			if val == 127 {
+				continue
+			}
+			for len(analogPins) <= int(val) {
+				analogPins = append(analogPins, -1)
 			}
-			// fmt.Println(index, ":", f.pins[index].AnalogChannel, ":", val)
+			analogPins[val] = index
 		}
-		f.logger.Printf("pin -> channel: %v\n", f.analogPins)
+		f.analogPins = analogPins
 		f.connected = true
+		f.mu.Unlock()
+		f.logger.Debugf("pin -> channel: %v", analogPins)
+		f.stageDone(StageAnalogMapping)
 	case PinStateResponse:
-		pin := data[0]
-		f.pins[pin].Mode = int(data[1])
-		f.pins[pin].State = int(data[2])
-
-		if len(data) > 3 {
-			f.pins[pin].State = int(uint(f.pins[pin].State) | uint(data[2])<<7)
+		f.mu.Lock()
+		if len(data) < 3 || int(data[0]) >= len(f.pins) {
+			f.mu.Unlock()
+			f.emit(ErrorEvent{fmt.Errorf("invalid pin state response: % X", data)})
+			return
 		}
-		if len(data) > 4 {
-			f.pins[pin].State = int(uint(f.pins[pin].State) | uint(data[4])<<14)
+		// State is sent 7 bits at a time, least significant first
+		state := PinState{Pin: int(data[0]), Mode: int(data[1])}
+		for i, val := range data[2:] {
+			state.State |= int(val) << uint(7*i)
 		}
-		f.logger.Printf("PinState%v", pin)
+		f.pins[state.Pin].Mode = state.Mode
+		f.pins[state.Pin].State = state.State
+		switch state.Mode {
+		case Output, Pwm, Servo:
+			// The state of an output is the value last written to it
+			f.pins[state.Pin].Value = state.State
+		}
+		f.mu.Unlock()
+		f.logger.Debugf("PinState%v", state.Pin)
+		f.deliverPinState(state)
+		f.emit(PinStateEvent{state})
 	case I2CReply:
+		if len(data) < 4 {
+			f.emit(ErrorEvent{fmt.Errorf("short I2C reply: % X", data)})
+			return
+		}
 		reply := I2cReply{
-			Address:  int(byte(data[0]) | byte(data[1])<<7),
-			Register: int(byte(data[2]) | byte(data[3])<<7),
-			Data:     []byte{byte(data[4]) | byte(data[5])<<7},
+			Address:  int(data[0]) | int(data[1])<<7,
+			Register: int(data[2]) | int(data[3])<<7,
+			Data:     []byte{},
 		}
-		for i := 8; i < len(data); i = i + 2 {
-			if data[i] == byte(0xF7) {
-				break
-			}
-			if i+2 > len(data) {
-				break
-			}
+		for i := 4; i+1 < len(data); i = i + 2 {
 			reply.Data = append(reply.Data,
 				byte(data[i])|byte(data[i+1])<<7,
 			)
 		}
-		f.logger.Printf("I2cReply%v", reply)
+		f.logger.Debugf("I2cReply%v", reply)
+		f.deliverI2cReply(reply)
+		f.emit(I2cReplyEvent{reply})
+	case SysExSPI:
+		f.parseSPI(data)
+	case Serial:
+		f.parseSerial(data)
 	case FirmwareQuery:
+		if len(data) < 2 {
+			f.emit(ErrorEvent{fmt.Errorf("short firmware reply: % X", data)})
+			return
+		}
 		name := []byte{}
-		for _, val := range data[2:(len(data) - 1)] {
+		for _, val := range data[2:] {
 			if val != 0 {
 				name = append(name, val)
 			}
 		}
-		f.FirmwareName = string(name[:])
-		f.logger.Printf("Firmware: %s", f.FirmwareName)
+		f.mu.Lock()
+		f.firmwareName = string(name[:])
+		f.mu.Unlock()
+		f.logger.Debugf("Firmware: %s", name)
+		f.emit(FirmwareEvent{Name: string(name), Major: int(data[0]), Minor: int(data[1])})
+		f.stageDone(StageFirmware)
 		f.CapabilitiesQuery()
 	case StringData:
-		str := data[:]
-		f.logger.Printf("StringData%v", string(str[:len(str)-1]))
+		str := decodeString(data)
+		f.logger.Debugf("StringData%v", str)
+		f.emit(StringEvent{str})
 	}
 }
 
-func (f *Firmata) printByteArray(title string, data []uint8) {
-	fmt.Println()
-	f.logger.Println(title)
-	str := ""
-	for index, b := range data {
-		str += fmt.Sprintf("0x%02X ", b)
-		if (index+1)%8 == 0 || index == len(data)-1 {
-			f.logger.Println(str)
-			str = ""
-		}
-	}
-	fmt.Println()
-}
-
-func (f *Firmata) printSysExData(title string, cmd SysExCommand, data []uint8) {
-	fmt.Println()
-	f.logger.Println(title, "-", cmd)
-	str := ""
-	for index, b := range data {
-		str += fmt.Sprintf("0x%02X ", b)
-		if (index+1)%8 == 0 || index == len(data)-1 {
-			f.logger.Println(str)
-			str = ""
+// decodeString joins the 7-bit LSB/MSB pairs of a string sysex message.
+func decodeString(data []byte) string {
+	str := []byte{}
+	for i := 0; i+1 < len(data); i += 2 {
+		if c := data[i] | data[i+1]<<7; c != 0 {
+			str = append(str, c)
 		}
 	}
-	fmt.Println()
+	return string(str)
 }
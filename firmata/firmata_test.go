@@ -0,0 +1,39 @@
+package firmata_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/argandas/goduino/firmata"
+	"github.com/argandas/goduino/firmata/sim"
+)
+
+func TestConnectEmptyFirmwareName(t *testing.T) {
+	board := sim.NewUno()
+	board.FirmwareName = ""
+	f := firmata.New()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := f.ConnectContext(ctx, board); err != nil {
+		t.Fatal(err)
+	}
+	defer f.Disconnect()
+	if name := f.FirmwareName(); name != "" {
+		t.Errorf("FirmwareName() = %q, want empty", name)
+	}
+	if version := f.ProtocolVersion(); version != "2.5" {
+		t.Errorf("ProtocolVersion() = %q, want 2.5", version)
+	}
+}
+
+func TestWriteNotConnected(t *testing.T) {
+	f := firmata.New()
+	if err := f.SetSamplingInterval(100 * time.Millisecond); !errors.Is(err, firmata.ErrNotConnected) {
+		t.Errorf("SetSamplingInterval() = %v, want ErrNotConnected", err)
+	}
+	if err := f.I2cWrite(0x48, []byte{1}); !errors.Is(err, firmata.ErrNotConnected) {
+		t.Errorf("I2cWrite() = %v, want ErrNotConnected", err)
+	}
+}
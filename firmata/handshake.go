@@ -0,0 +1,205 @@
+package firmata
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+)
+
+// HandshakeStage is a step of the connection handshake, each one waits for
+// the board to answer a query before the next is sent.
+type HandshakeStage int
+
+// Handshake stages, in the order they run
+const (
+	StageVersion HandshakeStage = iota
+	StageFirmware
+	StageCapability
+	StageAnalogMapping
+)
+
+func (s HandshakeStage) String() string {
+	switch s {
+	case StageVersion:
+		return "protocol version"
+	case StageFirmware:
+		return "firmware"
+	case StageCapability:
+		return "capability"
+	case StageAnalogMapping:
+		return "analog mapping"
+	}
+	return fmt.Sprintf("HandshakeStage(%d)", int(s))
+}
+
+// HandshakeError reports the stage at which a connection attempt failed.
+type HandshakeError struct {
+	Stage HandshakeStage
+	Err   error
+}
+
+func (e *HandshakeError) Error() string {
+	return fmt.Sprintf("firmata handshake failed waiting for %v response: %v", e.Stage, e.Err)
+}
+
+func (e *HandshakeError) Unwrap() error {
+	return e.Err
+}
+
+// HandshakeConfig controls how long Connect waits for the board.
+type HandshakeConfig struct {
+	// StageTimeout limits the wait for each answer once the board has
+	// reported its protocol version, 0 for no limit.
+	StageTimeout time.Duration
+	// ResetInterval is how often SystemReset is sent again while waiting for
+	// the protocol version, boards that reboot on open may miss the first one.
+	// 0 sends it only once.
+	ResetInterval time.Duration
+	// Timeout limits the whole handshake, 0 for no limit other than the
+	// context passed to ConnectContext.
+	Timeout time.Duration
+	// QueryRetries is how many times the query of a stage is sent again when
+	// StageTimeout expires before the stage fails. Links that lose packets,
+	// such as UDP, need a few.
+	QueryRetries int
+}
+
+// DefaultHandshake resets the board every 15 seconds and gives up after 30.
+var DefaultHandshake = HandshakeConfig{
+	StageTimeout:  5 * time.Second,
+	ResetInterval: 15 * time.Second,
+	Timeout:       30 * time.Second,
+}
+
+// SetHandshake sets the timeouts used by the next Connect.
+func (f *Firmata) SetHandshake(cfg HandshakeConfig) {
+	f.mu.Lock()
+	f.handshake = cfg
+	f.mu.Unlock()
+}
+
+// Handshake returns the timeouts used by Connect.
+func (f *Firmata) Handshake() HandshakeConfig {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.handshake
+}
+
+// ConnectContext connects to the Firmata given conn. It resets the board and
+// waits for the protocol version, firmware, capability and analog mapping
+// responses, then keeps polling the board for new information. If ctx is
+// done or a stage times out conn is closed and a *HandshakeError naming the
+// stage is returned.
+func (f *Firmata) ConnectContext(ctx context.Context, conn io.ReadWriteCloser) error {
+	f.mu.Lock()
+	if f.connected {
+		f.mu.Unlock()
+		return ErrConnected
+	}
+	cfg := f.handshake
+	stages := make(chan HandshakeStage, 4)
+	readErr := make(chan error, 1)
+	f.stages = stages
+	f.handshakeErr = readErr
+	f.closing = false
+	f.session++
+	f.connection = conn
+	f.mu.Unlock()
+
+	if cfg.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cfg.Timeout)
+		defer cancel()
+	}
+
+	// Start threads
+	go f.process()
+
+	fail := func(stage HandshakeStage, err error) error {
+		f.mu.Lock()
+		f.closing = true
+		f.mu.Unlock()
+		conn.Close()
+		return &HandshakeError{Stage: stage, Err: err}
+	}
+
+	// Reset device
+	if err := f.Reset(); err != nil {
+		return fail(StageVersion, err)
+	}
+
+	var reset <-chan time.Time
+	if cfg.ResetInterval > 0 {
+		t := time.NewTicker(cfg.ResetInterval)
+		defer t.Stop()
+		reset = t.C
+	}
+
+	stage := StageVersion
+	retries := 0
+	var stageTimeout <-chan time.Time
+	for {
+		select {
+		case done := <-stages:
+			if done < stage {
+				continue
+			}
+			if done == StageAnalogMapping {
+				// Firmata creation successful
+				f.logger.Infof("Firmata ready to use")
+				return nil
+			}
+			stage = done + 1
+			retries = 0
+			// The board is up, stop resetting it
+			reset = nil
+			if cfg.StageTimeout > 0 {
+				stageTimeout = time.After(cfg.StageTimeout)
+			}
+		case <-reset:
+			f.logger.Warnf("No response in %v. Resetting device", cfg.ResetInterval)
+			if err := f.Reset(); err != nil {
+				return fail(stage, err)
+			}
+		case err := <-readErr:
+			return fail(stage, err)
+		case <-stageTimeout:
+			if retries >= cfg.QueryRetries {
+				return fail(stage, ErrTimeout)
+			}
+			retries++
+			f.logger.Warnf("No %v response in %v. Sending the query again", stage, cfg.StageTimeout)
+			if err := f.stageQuery(stage); err != nil {
+				return fail(stage, err)
+			}
+			stageTimeout = time.After(cfg.StageTimeout)
+		case <-ctx.Done():
+			return fail(stage, ctx.Err())
+		}
+	}
+}
+
+// stageQuery sends the query answered by stage.
+func (f *Firmata) stageQuery(stage HandshakeStage) error {
+	switch stage {
+	case StageFirmware:
+		return f.FirmwareQuery()
+	case StageCapability:
+		return f.CapabilitiesQuery()
+	case StageAnalogMapping:
+		return f.AnalogMappingQuery()
+	}
+	return f.Reset()
+}
+
+// stageDone tells a running ConnectContext that the board answered stage.
+func (f *Firmata) stageDone(stage HandshakeStage) {
+	f.mu.RLock()
+	stages := f.stages
+	f.mu.RUnlock()
+	select {
+	case stages <- stage:
+	default:
+	}
+}
@@ -0,0 +1,246 @@
+package firmata
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// I2cSubscriptionBuffer is the number of replies a subscription holds before
+// new ones are dropped.
+const I2cSubscriptionBuffer = 16
+
+// I2CAddressMode selects between 7-bit and 10-bit slave addresses
+type I2CAddressMode byte
+
+// I2C address modes
+const (
+	I2CAddress7Bit  I2CAddressMode = 0x00
+	I2CAddress10Bit I2CAddressMode = 0x01
+)
+
+// Errors
+var (
+	ErrI2cAddress  = errors.New("i2c address out of range")
+	ErrI2cRegister = errors.New("i2c register out of range")
+	ErrI2cLength   = errors.New("i2c read length out of range")
+)
+
+// I2CRequestOptions controls how an I2C request is sent. The zero value
+// addresses a 7-bit device at the register it currently points to and ends
+// the transmission with a stop condition.
+type I2CRequestOptions struct {
+	// AddressMode selects 7-bit or 10-bit slave addressing
+	AddressMode I2CAddressMode
+	// Restart ends the transmission with a repeated start instead of a stop
+	Restart bool
+	// HasRegister sends Register before the data, otherwise the device
+	// uses the register it currently points to
+	HasRegister bool
+	// Register to read from or write to
+	Register int
+}
+
+// register returns the register of the request, -1 if it has none.
+func (opts I2CRequestOptions) register() int {
+	if !opts.HasRegister {
+		return -1
+	}
+	return opts.Register
+}
+
+// registerOptions returns the default request options for register, a
+// negative register is left out of the request.
+func registerOptions(register int) I2CRequestOptions {
+	return I2CRequestOptions{HasRegister: register >= 0, Register: register}
+}
+
+// validate checks opts against the limits of the I2C request message.
+func (opts I2CRequestOptions) validate(address int) error {
+	switch opts.AddressMode {
+	case I2CAddress7Bit:
+		if address < 0 || address > 0x7F {
+			return fmt.Errorf("%w: 0x%X is not a 7-bit address", ErrI2cAddress, address)
+		}
+	case I2CAddress10Bit:
+		if address < 0 || address > 0x3FF {
+			return fmt.Errorf("%w: 0x%X is not a 10-bit address", ErrI2cAddress, address)
+		}
+	default:
+		return fmt.Errorf("unknown i2c address mode %d", opts.AddressMode)
+	}
+	if opts.HasRegister && (opts.Register < 0 || opts.Register > 0x3FFF) {
+		return fmt.Errorf("%w: %d", ErrI2cRegister, opts.Register)
+	}
+	return nil
+}
+
+// I2cReadRequest reads numBytes from address once.
+func (f *Firmata) I2cReadRequest(address int, numBytes int, opts I2CRequestOptions) error {
+	if numBytes < 0 || numBytes > 0x3FFF {
+		return fmt.Errorf("%w: %d", ErrI2cLength, numBytes)
+	}
+	return f.i2cRequest(address, I2CModeRead, opts, []int{numBytes})
+}
+
+// I2cContinuousReadRequest asks the board to read numBytes from address on
+// every sampling interval until I2cStopReading is sent.
+func (f *Firmata) I2cContinuousReadRequest(address int, numBytes int, opts I2CRequestOptions) error {
+	if numBytes < 0 || numBytes > 0x3FFF {
+		return fmt.Errorf("%w: %d", ErrI2cLength, numBytes)
+	}
+	return f.i2cRequest(address, I2CModeContinuousRead, opts, []int{numBytes})
+}
+
+// I2cWriteRequest writes data to address, preceded by the register if one
+// is set.
+func (f *Firmata) I2cWriteRequest(address int, data []byte, opts I2CRequestOptions) error {
+	payload := []int{}
+	for _, val := range data {
+		payload = append(payload, int(val))
+	}
+	return f.i2cRequest(address, I2CModeWrite, opts, payload)
+}
+
+// i2cRequest builds and sends an I2CRequest sysex. The register, when set,
+// goes first, then every value of payload as a 7-bit LSB/MSB pair.
+func (f *Firmata) i2cRequest(address int, mode byte, opts I2CRequestOptions, payload []int) error {
+	if err := opts.validate(address); err != nil {
+		return err
+	}
+	// bit 6: restart, bit 5: 10-bit mode, bits 4-3: read/write mode,
+	// bits 2-0: address MSB
+	flags := mode << 3
+	if opts.Restart {
+		flags |= 1 << 6
+	}
+	if opts.AddressMode == I2CAddress10Bit {
+		flags |= 1<<5 | byte(address>>7)&0x07
+	}
+	ret := []byte{byte(I2CRequest), byte(address & 0x7F), flags}
+	if opts.HasRegister && mode != I2CModeStopReading {
+		payload = append([]int{opts.Register}, payload...)
+	}
+	for _, val := range payload {
+		ret = append(ret, byte(val&0x7F), byte((val>>7)&0x7F))
+	}
+	return f.writeSysex(ret)
+}
+
+// i2cWaiter is a read waiting for its I2cReply
+type i2cWaiter struct {
+	address  int
+	register int
+	reply    chan I2cReply
+}
+
+// i2cSubscriber receives every reply of a continuous read
+type i2cSubscriber struct {
+	i2cWaiter
+	once sync.Once
+}
+
+// matches reports whether reply answers the read, a negative register
+// matches any reply from the address.
+func (w *i2cWaiter) matches(reply I2cReply) bool {
+	return w.address == reply.Address && (w.register < 0 || w.register == reply.Register)
+}
+
+// I2cReadSync reads numBytes from address and waits up to timeout for the
+// board to reply. Replies are matched to pending reads by address and
+// register, in the order the reads were sent.
+func (f *Firmata) I2cReadSync(address int, numBytes int, opts I2CRequestOptions, timeout time.Duration) ([]byte, error) {
+	register := opts.register()
+	w := &i2cWaiter{address: address, register: register, reply: make(chan I2cReply, 1)}
+	f.pendingMu.Lock()
+	f.i2cWaiters = append(f.i2cWaiters, w)
+	f.pendingMu.Unlock()
+
+	if err := f.I2cReadRequest(address, numBytes, opts); err != nil {
+		f.removeI2cWaiter(w)
+		return nil, err
+	}
+
+	select {
+	case reply := <-w.reply:
+		return reply.Data, nil
+	case <-time.After(timeout):
+		f.removeI2cWaiter(w)
+		return nil, fmt.Errorf("i2c read from 0x%02X register %d: %w", address, register, ErrTimeout)
+	}
+}
+
+// I2cSubscribe starts a continuous read of numBytes from address and returns
+// a channel receiving every matching reply, along with a function that stops
+// the read and closes the channel. Replies that don't fit in the channel
+// buffer are dropped.
+func (f *Firmata) I2cSubscribe(address int, numBytes int, opts I2CRequestOptions) (<-chan I2cReply, func() error, error) {
+	s := &i2cSubscriber{i2cWaiter: i2cWaiter{
+		address:  address,
+		register: opts.register(),
+		reply:    make(chan I2cReply, I2cSubscriptionBuffer),
+	}}
+	f.pendingMu.Lock()
+	f.i2cSubscribers = append(f.i2cSubscribers, s)
+	f.pendingMu.Unlock()
+
+	cancel := func() (err error) {
+		s.once.Do(func() {
+			f.removeI2cSubscriber(s)
+			err = f.i2cRequest(address, I2CModeStopReading, opts, nil)
+		})
+		return
+	}
+	if err := f.I2cContinuousReadRequest(address, numBytes, opts); err != nil {
+		f.removeI2cSubscriber(s)
+		return nil, nil, err
+	}
+	return s.reply, cancel, nil
+}
+
+// deliverI2cReply hands reply to the oldest read waiting for it and to every
+// matching subscription.
+func (f *Firmata) deliverI2cReply(reply I2cReply) {
+	f.pendingMu.Lock()
+	defer f.pendingMu.Unlock()
+	for i, w := range f.i2cWaiters {
+		if w.matches(reply) {
+			f.i2cWaiters = append(f.i2cWaiters[:i:i], f.i2cWaiters[i+1:]...)
+			w.reply <- reply
+			break
+		}
+	}
+	for _, s := range f.i2cSubscribers {
+		if s.matches(reply) {
+			select {
+			case s.reply <- reply:
+			default:
+			}
+		}
+	}
+}
+
+// removeI2cSubscriber unregisters s and closes its channel.
+func (f *Firmata) removeI2cSubscriber(s *i2cSubscriber) {
+	f.pendingMu.Lock()
+	defer f.pendingMu.Unlock()
+	for i, p := range f.i2cSubscribers {
+		if p == s {
+			f.i2cSubscribers = append(f.i2cSubscribers[:i:i], f.i2cSubscribers[i+1:]...)
+			close(s.reply)
+			return
+		}
+	}
+}
+
+func (f *Firmata) removeI2cWaiter(w *i2cWaiter) {
+	f.pendingMu.Lock()
+	defer f.pendingMu.Unlock()
+	for i, p := range f.i2cWaiters {
+		if p == w {
+			f.i2cWaiters = append(f.i2cWaiters[:i:i], f.i2cWaiters[i+1:]...)
+			return
+		}
+	}
+}
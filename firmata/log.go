@@ -0,0 +1,140 @@
+package firmata
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Logger receives the diagnostic messages of the client. Its method set
+// matches the leveled loggers of logrus and zap's SugaredLogger, which can
+// be passed in directly.
+type Logger interface {
+	Debugf(format string, args ...interface{})
+	Infof(format string, args ...interface{})
+	Warnf(format string, args ...interface{})
+	Errorf(format string, args ...interface{})
+}
+
+// Level is the severity of a log message
+type Level int
+
+// Log levels, from the most verbose
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+func (l Level) String() string {
+	switch l {
+	case LevelDebug:
+		return "DEBUG"
+	case LevelInfo:
+		return "INFO"
+	case LevelWarn:
+		return "WARN"
+	case LevelError:
+		return "ERROR"
+	}
+	return fmt.Sprintf("Level(%d)", int(l))
+}
+
+// stdLogger writes messages of at least min level to a log.Logger
+type stdLogger struct {
+	l   *log.Logger
+	min Level
+}
+
+// StdLogger returns a Logger writing messages of level min and above to l,
+// each prefixed with its level.
+func StdLogger(l *log.Logger, min Level) Logger {
+	return stdLogger{l, min}
+}
+
+func (s stdLogger) logf(level Level, format string, args []interface{}) {
+	if level >= s.min {
+		s.l.Printf("%-5v "+format, append([]interface{}{level}, args...)...)
+	}
+}
+
+func (s stdLogger) Debugf(format string, args ...interface{}) { s.logf(LevelDebug, format, args) }
+func (s stdLogger) Infof(format string, args ...interface{})  { s.logf(LevelInfo, format, args) }
+func (s stdLogger) Warnf(format string, args ...interface{})  { s.logf(LevelWarn, format, args) }
+func (s stdLogger) Errorf(format string, args ...interface{}) { s.logf(LevelError, format, args) }
+
+// Discard is a Logger dropping every message, the default of New
+var Discard Logger = nopLogger{}
+
+// nopLogger discards every message
+type nopLogger struct{}
+
+func (nopLogger) Debugf(string, ...interface{}) {}
+func (nopLogger) Infof(string, ...interface{})  {}
+func (nopLogger) Warnf(string, ...interface{})  {}
+func (nopLogger) Errorf(string, ...interface{}) {}
+
+// Direction tells whether a frame was sent to or received from the board
+type Direction int
+
+// Frame directions
+const (
+	Tx Direction = iota
+	Rx
+)
+
+func (d Direction) String() string {
+	if d == Rx {
+		return "RX"
+	}
+	return "TX"
+}
+
+// Tracer receives every frame written to and read from the board. frame is
+// only valid during the call and must be copied to be retained. Tracers run
+// on the goroutine doing the I/O and must not block.
+type Tracer interface {
+	TraceFrame(dir Direction, frame []byte)
+}
+
+// hexTracer writes a hex dump of every frame to w
+type hexTracer struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// HexDump returns a Tracer writing one line per frame to w, with the time,
+// direction, command and the bytes in hex.
+func HexDump(w io.Writer) Tracer {
+	return &hexTracer{w: w}
+}
+
+func (t *hexTracer) TraceFrame(dir Direction, frame []byte) {
+	if len(frame) == 0 {
+		return
+	}
+	var name string
+	if FirmataCommand(frame[0]) == StartSysex && len(frame) > 1 {
+		name = SysExCommand(frame[1]).String()
+	} else {
+		name = FirmataCommand(frame[0]).String()
+	}
+	hex := make([]string, len(frame))
+	for i, b := range frame {
+		hex[i] = fmt.Sprintf("%02X", b)
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	fmt.Fprintf(t.w, "%s %v %s: %s\n", time.Now().Format("15:04:05.000"), dir, name, strings.Join(hex, " "))
+}
+
+// trace hands a frame to the tracer, if any.
+func (f *Firmata) trace(dir Direction, frame []byte) {
+	if f.tracer != nil {
+		f.tracer.TraceFrame(dir, frame)
+	}
+}
@@ -0,0 +1,92 @@
+package firmata
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestParseShortSysEx(t *testing.T) {
+	tests := [][]byte{
+		{byte(FirmwareQuery)},
+		{byte(FirmwareQuery), 2},
+		{byte(I2CReply), 1, 0},
+		{byte(PinStateResponse), 200},
+	}
+	for _, data := range tests {
+		f := New()
+		f.parseSysEx(data)
+		select {
+		case e := <-f.Events():
+			if _, ok := e.(ErrorEvent); !ok {
+				t.Errorf("% X: got %#v, want an ErrorEvent", data, e)
+			}
+		default:
+			t.Errorf("% X: no event", data)
+		}
+	}
+}
+
+func TestParseEmptySysEx(t *testing.T) {
+	f := New()
+	f.parseSysEx(nil)
+	if e, ok := (<-f.Events()).(ErrorEvent); !ok || !errors.Is(e.Err, ErrEmptySysEx) {
+		t.Errorf("got %#v, want ErrEmptySysEx", e)
+	}
+}
+
+func TestParseCapabilityResponse(t *testing.T) {
+	f := New()
+	f.parseSysEx([]byte{byte(CapabilityResponse),
+		// digital pin with PWM and servo
+		byte(Input), 1, byte(Output), 1, byte(Pwm), 8, byte(Servo), 14, 127,
+		// pin with no modes
+		127,
+		// analog pin with a 12-bit ADC and I2C
+		byte(Analog), 12, byte(Input), 1, byte(I2C), 1, 127,
+	})
+	want := []map[int]int{
+		{Input: 1, Output: 1, Pwm: 8, Servo: 14},
+		{},
+		{Analog: 12, Input: 1, I2C: 1},
+	}
+	pins := f.Pins()
+	if len(pins) != len(want) {
+		t.Fatalf("got %d pins, want %d", len(pins), len(want))
+	}
+	for i, modes := range want {
+		if !reflect.DeepEqual(pins[i].SupportedModes, modes) {
+			t.Errorf("pin %d SupportedModes = %v, want %v", i, pins[i].SupportedModes, modes)
+		}
+	}
+}
+
+// frameConn records what is written to it
+type frameConn struct {
+	bytes.Buffer
+}
+
+func (c *frameConn) Close() error { return nil }
+
+func TestI2CRequestRegister(t *testing.T) {
+	tests := []struct {
+		opts I2CRequestOptions
+		want []byte
+	}{
+		{I2CRequestOptions{}, []byte{0xF0, 0x76, 0x48, 0x08, 0x02, 0x00, 0xF7}},
+		{I2CRequestOptions{HasRegister: true}, []byte{0xF0, 0x76, 0x48, 0x08, 0x00, 0x00, 0x02, 0x00, 0xF7}},
+		{I2CRequestOptions{HasRegister: true, Register: 0x81}, []byte{0xF0, 0x76, 0x48, 0x08, 0x01, 0x01, 0x02, 0x00, 0xF7}},
+	}
+	for _, tt := range tests {
+		conn := &frameConn{}
+		f := New()
+		f.connection = conn
+		if err := f.I2cReadRequest(0x48, 2, tt.opts); err != nil {
+			t.Fatal(err)
+		}
+		if got := conn.Bytes(); !bytes.Equal(got, tt.want) {
+			t.Errorf("%+v: sent % X, want % X", tt.opts, got, tt.want)
+		}
+	}
+}
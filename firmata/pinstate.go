@@ -0,0 +1,68 @@
+package firmata
+
+import (
+	"context"
+	"fmt"
+)
+
+// PinState represents the answer to a PinStateQuery. For outputs State is
+// the value last written, for inputs it tells whether the pull-up is on.
+type PinState struct {
+	Pin   int
+	Mode  int
+	State int
+}
+
+// pinStateWaiter is a query waiting for its PinStateResponse
+type pinStateWaiter struct {
+	pin   int
+	reply chan PinState
+}
+
+// QueryPinState sends a PinStateQuery for pin and waits for the response
+// until ctx is done.
+func (f *Firmata) QueryPinState(ctx context.Context, pin int) (PinState, error) {
+	w := &pinStateWaiter{pin: pin, reply: make(chan PinState, 1)}
+	f.pendingMu.Lock()
+	f.pinStateWaiters = append(f.pinStateWaiters, w)
+	f.pendingMu.Unlock()
+
+	if err := f.PinStateQuery(pin); err != nil {
+		f.removePinStateWaiter(w)
+		return PinState{}, err
+	}
+
+	select {
+	case state := <-w.reply:
+		return state, nil
+	case <-ctx.Done():
+		f.removePinStateWaiter(w)
+		return PinState{}, fmt.Errorf("pin state query for pin %d: %w", pin, ctx.Err())
+	}
+}
+
+// deliverPinState hands state to every query waiting for its pin.
+func (f *Firmata) deliverPinState(state PinState) {
+	f.pendingMu.Lock()
+	defer f.pendingMu.Unlock()
+	waiters := f.pinStateWaiters[:0]
+	for _, w := range f.pinStateWaiters {
+		if w.pin == state.Pin {
+			w.reply <- state
+			continue
+		}
+		waiters = append(waiters, w)
+	}
+	f.pinStateWaiters = waiters
+}
+
+func (f *Firmata) removePinStateWaiter(w *pinStateWaiter) {
+	f.pendingMu.Lock()
+	defer f.pendingMu.Unlock()
+	for i, p := range f.pinStateWaiters {
+		if p == w {
+			f.pinStateWaiters = append(f.pinStateWaiters[:i:i], f.pinStateWaiters[i+1:]...)
+			return
+		}
+	}
+}
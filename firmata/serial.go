@@ -0,0 +1,86 @@
+package firmata
+
+import "fmt"
+
+// SerialData represents data received on one of the board's serial ports
+type SerialData struct {
+	Port SerialPort
+	Data []byte
+}
+
+// ConfigureSerial opens port at baud. rxPin and txPin select the pins of a
+// software serial port and are ignored for hardware ports.
+func (f *Firmata) ConfigureSerial(port SerialPort, baud int, rxPin int, txPin int) error {
+	ret := []byte{
+		byte(Serial),
+		byte(SerialConfig) | byte(port),
+		byte(baud & 0x7F),
+		byte((baud >> 7) & 0x7F),
+		byte((baud >> 14) & 0x7F),
+	}
+	if port >= SoftSerial {
+		ret = append(ret, byte(rxPin), byte(txPin))
+	}
+	return f.writeSysex(ret)
+}
+
+// WriteSerial sends data out of port.
+func (f *Firmata) WriteSerial(port SerialPort, data []byte) error {
+	ret := []byte{byte(Serial), byte(SerialWrite) | byte(port)}
+	for _, val := range data {
+		ret = append(ret, val&0x7F, (val>>7)&0x7F)
+	}
+	return f.writeSysex(ret)
+}
+
+// ReadSerial starts (SerialReadContinuous) or stops (SerialStopReading)
+// forwarding of the data received on port. A positive maxBytes limits the
+// number of bytes sent per reply.
+func (f *Firmata) ReadSerial(port SerialPort, mode byte, maxBytes int) error {
+	ret := []byte{byte(Serial), byte(SerialRead) | byte(port), mode}
+	if maxBytes > 0 {
+		ret = append(ret, byte(maxBytes&0x7F), byte((maxBytes>>7)&0x7F))
+	}
+	return f.writeSysex(ret)
+}
+
+// FlushSerial flushes the buffers of port.
+func (f *Firmata) FlushSerial(port SerialPort) error {
+	return f.writeSysex([]byte{byte(Serial), byte(SerialFlush) | byte(port)})
+}
+
+// CloseSerial closes port.
+func (f *Firmata) CloseSerial(port SerialPort) error {
+	return f.writeSysex([]byte{byte(Serial), byte(SerialClose) | byte(port)})
+}
+
+// OnSerialData sets the function receiving the data read from port, a nil
+// fn removes it. It runs on the process goroutine and must not block.
+func (f *Firmata) OnSerialData(port SerialPort, fn func([]byte)) {
+	f.pendingMu.Lock()
+	defer f.pendingMu.Unlock()
+	if fn == nil {
+		delete(f.serialHandlers, port)
+		return
+	}
+	f.serialHandlers[port] = fn
+}
+
+// parseSerial decodes a Serial sysex sent by the board.
+func (f *Firmata) parseSerial(data []byte) {
+	if len(data) < 1 || SerialSubCommand(data[0]&0xF0) != SerialReply {
+		f.emit(ErrorEvent{fmt.Errorf("unexpected serial message: % X", data)})
+		return
+	}
+	reply := SerialData{Port: SerialPort(data[0] & 0x0F), Data: []byte{}}
+	for i := 1; i+1 < len(data); i = i + 2 {
+		reply.Data = append(reply.Data, data[i]|data[i+1]<<7)
+	}
+	f.pendingMu.Lock()
+	fn := f.serialHandlers[reply.Port]
+	f.pendingMu.Unlock()
+	if fn != nil {
+		fn(reply.Data)
+	}
+	f.emit(SerialEvent{reply})
+}
@@ -0,0 +1,147 @@
+package sim
+
+import (
+	"time"
+
+	"github.com/argandas/goduino/firmata"
+)
+
+// I2CDevice is a simulated I2C slave with a flat register file.
+type I2CDevice struct {
+	Registers []byte
+	pointer   int
+}
+
+// i2cQuery is a continuous read repeated on every sampling interval
+type i2cQuery struct {
+	address  int
+	register int
+	numBytes int
+}
+
+// AddI2CDevice attaches a device with size registers at address and returns
+// it so tests can preload or inspect its registers.
+func (b *Board) AddI2CDevice(address, size int) *I2CDevice {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	dev := &I2CDevice{Registers: make([]byte, size)}
+	b.i2c[address] = dev
+	return dev
+}
+
+// I2CDevice returns the device attached at address, or nil.
+func (b *Board) I2CDevice(address int) *I2CDevice {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.i2c[address]
+}
+
+func (b *Board) handleI2C(data []byte) {
+	if len(data) < 2 {
+		return
+	}
+	address := int(data[0])
+	if data[1]&0x20 != 0 {
+		// 10-bit address, the upper bits ride in the mode byte
+		address |= int(data[1]&0x07) << 7
+	}
+	mode := (data[1] >> 3) & 0x03
+	args := decode(data[2:])
+	switch mode {
+	case firmata.I2CModeWrite:
+		dev, ok := b.i2c[address]
+		if !ok || len(args) == 0 {
+			return
+		}
+		dev.pointer = int(args[0])
+		for _, val := range args[1:] {
+			dev.write(val)
+		}
+	case firmata.I2CModeRead, firmata.I2CModeContinuousRead:
+		q := i2cQuery{address: address, register: -1}
+		switch len(args) {
+		case 1:
+			q.numBytes = int(data[2]) | int(data[3])<<7
+		case 2:
+			q.register = int(data[2]) | int(data[3])<<7
+			q.numBytes = int(data[4]) | int(data[5])<<7
+		default:
+			return
+		}
+		if mode == firmata.I2CModeRead {
+			b.readI2C(q)
+			return
+		}
+		b.i2cQueries = append(b.i2cQueries, q)
+		if !b.sampling {
+			b.sampling = true
+			go b.sample()
+		}
+	case firmata.I2CModeStopReading:
+		for i, q := range b.i2cQueries {
+			if q.address == address {
+				b.i2cQueries = append(b.i2cQueries[:i:i], b.i2cQueries[i+1:]...)
+				break
+			}
+		}
+	}
+}
+
+// sample repeats the continuous reads until the board is closed.
+func (b *Board) sample() {
+	for {
+		b.mu.Lock()
+		interval := b.samplingInterval
+		b.mu.Unlock()
+		time.Sleep(interval)
+
+		b.mu.Lock()
+		if b.closed {
+			b.mu.Unlock()
+			return
+		}
+		for _, q := range b.i2cQueries {
+			b.readI2C(q)
+		}
+		b.mu.Unlock()
+	}
+}
+
+func (b *Board) readI2C(q i2cQuery) {
+	dev, ok := b.i2c[q.address]
+	if !ok {
+		return
+	}
+	if q.register >= 0 {
+		dev.pointer = q.register
+	}
+	b.sendI2CReply(q.address, dev, q.numBytes)
+}
+
+func (b *Board) sendI2CReply(address int, dev *I2CDevice, numBytes int) {
+	register := dev.pointer
+	frame := []byte{byte(firmata.I2CReply),
+		byte(address & 0x7F), byte((address >> 7) & 0x7F),
+		byte(register & 0x7F), byte((register >> 7) & 0x7F)}
+	for i := 0; i < numBytes; i++ {
+		frame = append(frame, encode([]byte{dev.read()})...)
+	}
+	b.sendSysex(frame...)
+}
+
+func (d *I2CDevice) read() byte {
+	if len(d.Registers) == 0 {
+		return 0
+	}
+	val := d.Registers[d.pointer%len(d.Registers)]
+	d.pointer = (d.pointer + 1) % len(d.Registers)
+	return val
+}
+
+func (d *I2CDevice) write(val byte) {
+	if len(d.Registers) == 0 {
+		return
+	}
+	d.Registers[d.pointer%len(d.Registers)] = val
+	d.pointer = (d.pointer + 1) % len(d.Registers)
+}
@@ -0,0 +1,71 @@
+package sim
+
+import "github.com/argandas/goduino/firmata"
+
+// serialPort is a serial port of the simulated board
+type serialPort struct {
+	baud    int
+	reading bool
+	written []byte
+}
+
+// SerialInput simulates data arriving on port, it is forwarded to the host
+// if the port is open and being read.
+func (b *Board) SerialInput(port firmata.SerialPort, data []byte) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if sp, ok := b.serial[port]; ok && sp.reading {
+		frame := []byte{byte(firmata.Serial), byte(firmata.SerialReply) | byte(port)}
+		b.sendSysex(append(frame, encode(data)...)...)
+	}
+}
+
+// SerialOutput returns the data the host wrote to port since the last call.
+func (b *Board) SerialOutput(port firmata.SerialPort) []byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	sp, ok := b.serial[port]
+	if !ok {
+		return nil
+	}
+	data := sp.written
+	sp.written = nil
+	return data
+}
+
+// SerialBaud returns the baud rate port was opened at, or 0 if it is closed.
+func (b *Board) SerialBaud(port firmata.SerialPort) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if sp, ok := b.serial[port]; ok {
+		return sp.baud
+	}
+	return 0
+}
+
+func (b *Board) handleSerial(data []byte) {
+	if len(data) < 1 {
+		return
+	}
+	port := firmata.SerialPort(data[0] & 0x0F)
+	cmd := firmata.SerialSubCommand(data[0] & 0xF0)
+	if cmd == firmata.SerialConfig {
+		if len(data) < 4 {
+			return
+		}
+		b.serial[port] = &serialPort{baud: int(data[1]) | int(data[2])<<7 | int(data[3])<<14}
+		return
+	}
+	sp, ok := b.serial[port]
+	if !ok {
+		return
+	}
+	switch cmd {
+	case firmata.SerialWrite:
+		sp.written = append(sp.written, decode(data[1:])...)
+	case firmata.SerialRead:
+		sp.reading = len(data) > 1 && data[1] == firmata.SerialReadContinuous
+	case firmata.SerialClose:
+		delete(b.serial, port)
+	}
+}
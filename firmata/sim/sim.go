@@ -0,0 +1,417 @@
+// Package sim provides an in-memory Firmata board that speaks the device side
+// of the protocol, so Goduino and firmata clients can be exercised without
+// hardware.
+package sim
+
+import (
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/argandas/goduino/firmata"
+)
+
+// Errors
+var ErrClosed = errors.New("sim: board is closed")
+
+// NoChannel marks a pin without an analog channel in the analog mapping.
+const NoChannel = 127
+
+// Pin describes a simulated pin
+type Pin struct {
+	Modes         map[int]int // supported mode -> resolution in bits
+	AnalogChannel int         // NoChannel if the pin is digital only
+	Mode          int
+	Value         int
+	ServoMin      int // pulse range set by ServoConfig, in microseconds
+	ServoMax      int
+}
+
+// Board is a simulated Firmata board. It implements io.ReadWriteCloser, the
+// host writes Firmata frames to it and reads the board's answers back.
+type Board struct {
+	FirmwareName string
+	Major        int
+	Minor        int
+
+	mu            sync.Mutex
+	cond          *sync.Cond
+	out           []byte
+	in            []byte
+	closed        bool
+	pins          []Pin
+	reportDigital map[int]bool
+	reportAnalog  map[int]bool
+	i2c           map[int]*I2CDevice
+	i2cQueries    []i2cQuery
+	spi           map[byte]*spiDevice
+	serial        map[firmata.SerialPort]*serialPort
+
+	samplingInterval time.Duration
+	sampling         bool
+}
+
+// New returns a simulated board with the given pin layout.
+func New(pins []Pin) *Board {
+	b := &Board{
+		FirmwareName:  "StandardFirmata.ino",
+		Major:         2,
+		Minor:         5,
+		pins:          append([]Pin{}, pins...),
+		reportDigital: map[int]bool{},
+		reportAnalog:  map[int]bool{},
+		i2c:           map[int]*I2CDevice{},
+		spi:           map[byte]*spiDevice{},
+		serial:        map[firmata.SerialPort]*serialPort{},
+
+		samplingInterval: 19 * time.Millisecond,
+	}
+	b.cond = sync.NewCond(&b.mu)
+	return b
+}
+
+// NewUno returns a simulated Arduino Uno running StandardFirmata.
+func NewUno() *Board {
+	pins := []Pin{}
+	for i := 0; i < 20; i++ {
+		p := Pin{
+			Modes:         map[int]int{firmata.Input: 1, firmata.Output: 1, firmata.Pullup: 1},
+			AnalogChannel: NoChannel,
+			Mode:          firmata.Output,
+		}
+		switch {
+		case i < 2:
+			// Rx/Tx are kept for the serial link
+			p.Modes = map[int]int{}
+		case i < 14:
+			p.Modes[firmata.Servo] = 14
+			if i == 3 || i == 5 || i == 6 || i == 9 || i == 10 || i == 11 {
+				p.Modes[firmata.Pwm] = 8
+			}
+		default:
+			p.Modes[firmata.Analog] = 10
+			p.AnalogChannel = i - 14
+			if i == 18 || i == 19 {
+				p.Modes[firmata.I2C] = 1
+			}
+		}
+		pins = append(pins, p)
+	}
+	return New(pins)
+}
+
+// Read reads frames sent by the board, it blocks until data is available or
+// the board is closed.
+func (b *Board) Read(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for len(b.out) == 0 && !b.closed {
+		b.cond.Wait()
+	}
+	if b.closed {
+		return 0, io.EOF
+	}
+	n := copy(p, b.out)
+	b.out = b.out[n:]
+	return n, nil
+}
+
+// Write feeds host frames to the board. Incomplete frames are kept until
+// the rest of their bytes arrive.
+func (b *Board) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return 0, ErrClosed
+	}
+	b.in = append(b.in, p...)
+	for {
+		n := b.handle(b.in)
+		if n == 0 {
+			break
+		}
+		b.in = b.in[n:]
+	}
+	return len(p), nil
+}
+
+// Close closes the board, pending and future reads return io.EOF.
+func (b *Board) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	b.cond.Broadcast()
+	return nil
+}
+
+// Pin returns a copy of the state of pin.
+func (b *Board) Pin(pin int) Pin {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.pins[pin]
+}
+
+// SamplingInterval returns the sampling interval set by the host.
+func (b *Board) SamplingInterval() time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.samplingInterval
+}
+
+// SetDigital drives an input pin to value as if an external circuit did,
+// the port is reported to the host when digital reporting is enabled.
+func (b *Board) SetDigital(pin, value int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.pins[pin].Value = value
+	if b.reportDigital[pin/8] {
+		b.sendPort(pin / 8)
+	}
+}
+
+// SetAnalog sets the reading of analog channel to value, it is reported to
+// the host when analog reporting is enabled for the channel.
+func (b *Board) SetAnalog(channel, value int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	pin := b.analogPin(channel)
+	if pin < 0 {
+		return
+	}
+	b.pins[pin].Value = value
+	if b.reportAnalog[channel] {
+		b.sendAnalog(channel)
+	}
+}
+
+// handle processes the first message in buf and returns the number of bytes
+// consumed, or 0 if the message is not complete yet.
+func (b *Board) handle(buf []byte) int {
+	if len(buf) == 0 {
+		return 0
+	}
+	cmd := firmata.FirmataCommand(buf[0])
+	switch {
+	case cmd == firmata.SystemReset:
+		b.reset()
+		b.send(byte(firmata.ProtocolVersion), byte(b.Major), byte(b.Minor))
+		return 1
+	case cmd == firmata.ProtocolVersion:
+		b.send(byte(firmata.ProtocolVersion), byte(b.Major), byte(b.Minor))
+		return 1
+	case cmd == firmata.StartSysex:
+		for i, c := range buf {
+			if c == byte(firmata.EndSysex) {
+				if i > 1 {
+					b.handleSysex(firmata.SysExCommand(buf[1]), buf[2:i])
+				}
+				return i + 1
+			}
+		}
+		return 0
+	case cmd == firmata.PinMode,
+		firmata.DigitalMessageRangeStart <= cmd && cmd <= firmata.DigitalMessageRangeEnd,
+		firmata.AnalogMessageRangeStart <= cmd && cmd <= firmata.AnalogMessageRangeEnd:
+		if len(buf) < 3 {
+			return 0
+		}
+		b.handleMessage(cmd, buf[1], buf[2])
+		return 3
+	case cmd&0xF0 == firmata.ReportAnalog, cmd&0xF0 == firmata.ReportDigital:
+		if len(buf) < 2 {
+			return 0
+		}
+		b.handleReport(cmd, buf[1])
+		return 2
+	}
+	// Unknown byte, skip it
+	return 1
+}
+
+func (b *Board) handleMessage(cmd firmata.FirmataCommand, b1, b2 byte) {
+	switch {
+	case cmd == firmata.PinMode:
+		pin := int(b1)
+		if pin < len(b.pins) {
+			if _, ok := b.pins[pin].Modes[int(b2)]; ok {
+				b.pins[pin].Mode = int(b2)
+				if b2 == firmata.Pullup {
+					// Nothing drives the pin yet, the pull-up holds it high
+					b.pins[pin].Value = 1
+				}
+			}
+		}
+	case cmd&0xF0 == firmata.DigitalMessage:
+		port := int(cmd & 0x0F)
+		value := int(b1) | int(b2)<<7
+		for i := 0; i < 8; i++ {
+			pin := 8*port + i
+			if pin < len(b.pins) && b.pins[pin].Mode == firmata.Output {
+				b.pins[pin].Value = (value >> uint(i)) & 0x01
+			}
+		}
+	case cmd&0xF0 == firmata.AnalogMessage:
+		pin := int(cmd & 0x0F)
+		if pin < len(b.pins) {
+			b.pins[pin].Value = int(b1) | int(b2)<<7
+		}
+	}
+}
+
+func (b *Board) handleReport(cmd firmata.FirmataCommand, state byte) {
+	n := int(cmd & 0x0F)
+	if cmd&0xF0 == firmata.ReportDigital {
+		b.reportDigital[n] = state != 0
+		if state != 0 {
+			b.sendPort(n)
+		}
+		return
+	}
+	b.reportAnalog[n] = state != 0
+	if state != 0 {
+		b.sendAnalog(n)
+	}
+}
+
+func (b *Board) handleSysex(cmd firmata.SysExCommand, data []byte) {
+	switch cmd {
+	case firmata.FirmwareQuery:
+		frame := []byte{byte(firmata.FirmwareQuery), byte(b.Major), byte(b.Minor)}
+		b.sendSysex(append(frame, encode([]byte(b.FirmwareName))...)...)
+	case firmata.CapabilityQuery:
+		frame := []byte{byte(firmata.CapabilityResponse)}
+		for _, p := range b.pins {
+			for mode := 0; mode < 0x7F; mode++ {
+				if res, ok := p.Modes[mode]; ok {
+					frame = append(frame, byte(mode), byte(res))
+				}
+			}
+			frame = append(frame, 0x7F)
+		}
+		b.sendSysex(frame...)
+	case firmata.AnalogMappingQuery:
+		frame := []byte{byte(firmata.AnalogMappingResponse)}
+		for _, p := range b.pins {
+			frame = append(frame, byte(p.AnalogChannel))
+		}
+		b.sendSysex(frame...)
+	case firmata.PinStateQuery:
+		if len(data) < 1 || int(data[0]) >= len(b.pins) {
+			return
+		}
+		p := b.pins[data[0]]
+		frame := []byte{byte(firmata.PinStateResponse), data[0], byte(p.Mode)}
+		state := p.Value
+		for {
+			frame = append(frame, byte(state&0x7F))
+			state >>= 7
+			if state == 0 {
+				break
+			}
+		}
+		b.sendSysex(frame...)
+	case firmata.ServoConfig:
+		if len(data) < 5 || int(data[0]) >= len(b.pins) {
+			return
+		}
+		p := &b.pins[data[0]]
+		if _, ok := p.Modes[firmata.Servo]; ok {
+			p.Mode = firmata.Servo
+			p.ServoMin = int(data[1]) | int(data[2])<<7
+			p.ServoMax = int(data[3]) | int(data[4])<<7
+		}
+	case firmata.ExtendedAnalog:
+		if len(data) < 2 || int(data[0]) >= len(b.pins) {
+			return
+		}
+		value := 0
+		for i, val := range data[1:] {
+			value |= int(val) << uint(7*i)
+		}
+		b.pins[data[0]].Value = value
+	case firmata.SamplingInterval:
+		if len(data) < 2 {
+			return
+		}
+		b.samplingInterval = time.Duration(int(data[0])|int(data[1])<<7) * time.Millisecond
+	case firmata.I2CRequest:
+		b.handleI2C(data)
+	case firmata.SysExSPI:
+		b.handleSPI(data)
+	case firmata.Serial:
+		b.handleSerial(data)
+	}
+}
+
+// reset restores the power-on state of the board.
+func (b *Board) reset() {
+	b.out = nil
+	b.reportDigital = map[int]bool{}
+	b.reportAnalog = map[int]bool{}
+	b.i2cQueries = nil
+	for i := range b.pins {
+		b.pins[i].Mode = firmata.Output
+		if b.pins[i].AnalogChannel != NoChannel {
+			b.pins[i].Mode = firmata.Analog
+		}
+	}
+}
+
+func (b *Board) analogPin(channel int) int {
+	for i, p := range b.pins {
+		if p.AnalogChannel == channel {
+			return i
+		}
+	}
+	return -1
+}
+
+func (b *Board) sendPort(port int) {
+	value := 0
+	for i := 0; i < 8; i++ {
+		pin := 8*port + i
+		if pin < len(b.pins) && b.pins[pin].Value != 0 {
+			value |= 1 << uint(i)
+		}
+	}
+	b.send(byte(firmata.DigitalMessage)|byte(port), byte(value&0x7F), byte((value>>7)&0x7F))
+}
+
+func (b *Board) sendAnalog(channel int) {
+	pin := b.analogPin(channel)
+	if pin < 0 || channel > 0x0F {
+		return
+	}
+	value := b.pins[pin].Value
+	b.send(byte(firmata.AnalogMessage)|byte(channel), byte(value&0x7F), byte((value>>7)&0x7F))
+}
+
+func (b *Board) sendSysex(data ...byte) {
+	b.send(byte(firmata.StartSysex))
+	b.send(data...)
+	b.send(byte(firmata.EndSysex))
+}
+
+func (b *Board) send(data ...byte) {
+	b.out = append(b.out, data...)
+	b.cond.Broadcast()
+}
+
+// encode splits data into 7-bit LSB/MSB pairs.
+func encode(data []byte) []byte {
+	ret := []byte{}
+	for _, val := range data {
+		ret = append(ret, val&0x7F, (val>>7)&0x7F)
+	}
+	return ret
+}
+
+// decode joins 7-bit LSB/MSB pairs.
+func decode(data []byte) []byte {
+	ret := []byte{}
+	for i := 0; i+1 < len(data); i += 2 {
+		ret = append(ret, data[i]|data[i+1]<<7)
+	}
+	return ret
+}
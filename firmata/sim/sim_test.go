@@ -0,0 +1,138 @@
+package sim_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/argandas/goduino"
+	"github.com/argandas/goduino/firmata"
+	"github.com/argandas/goduino/firmata/sim"
+)
+
+func connect(t *testing.T, board *sim.Board) *goduino.Goduino {
+	t.Helper()
+	arduino, err := goduino.New("sim", goduino.WithConn(board))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := arduino.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { arduino.Disconnect() })
+	return arduino
+}
+
+func TestHandshake(t *testing.T) {
+	board := sim.NewUno()
+	arduino := connect(t, board)
+	if arduino.State() != goduino.StateConnected {
+		t.Fatalf("state = %v", arduino.State())
+	}
+	// An Uno maps A0 to pin 14
+	if pin, err := arduino.A(0); err != nil || pin != 14 {
+		t.Errorf("A(0) = %d, %v, want 14", pin, err)
+	}
+}
+
+func TestDigitalRead(t *testing.T) {
+	board := sim.NewUno()
+	arduino := connect(t, board)
+	if err := arduino.PinMode(2, goduino.Input); err != nil {
+		t.Fatal(err)
+	}
+	changed := make(chan int, 1)
+	arduino.OnDigitalChange(2, func(old, new int) { changed <- new })
+	board.SetDigital(2, 1)
+	select {
+	case <-changed:
+	case <-time.After(time.Second):
+		t.Fatal("pin 2 change not reported")
+	}
+	if v, err := arduino.DigitalRead(2); err != nil || v != 1 {
+		t.Errorf("DigitalRead(2) = %d, %v, want 1", v, err)
+	}
+}
+
+func TestAnalogRead(t *testing.T) {
+	board := sim.NewUno()
+	arduino := connect(t, board)
+	if _, err := arduino.AnalogRead(0); err != nil {
+		t.Fatal(err)
+	}
+	changed := make(chan int, 1)
+	arduino.OnAnalogChange(0, 1, func(value int) {
+		if value == 512 {
+			changed <- value
+		}
+	})
+	board.SetAnalog(0, 512)
+	select {
+	case <-changed:
+	case <-time.After(time.Second):
+		t.Fatal("A0 change not reported")
+	}
+	if v, err := arduino.AnalogRead(0); err != nil || v != 512 {
+		t.Errorf("AnalogRead(0) = %d, %v, want 512", v, err)
+	}
+}
+
+func TestI2CRead(t *testing.T) {
+	board := sim.NewUno()
+	dev := board.AddI2CDevice(0x48, 16)
+	copy(dev.Registers[4:], []byte{0xDE, 0xAD})
+	arduino := connect(t, board)
+	data, err := arduino.I2CRead(0x48, 4, 2, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, []byte{0xDE, 0xAD}) {
+		t.Errorf("I2CRead() = % X, want DE AD", data)
+	}
+}
+
+func TestInvalidPin(t *testing.T) {
+	arduino := connect(t, sim.NewUno())
+	if err := arduino.PinMode(20, goduino.Output); !errors.Is(err, goduino.ErrInvalidPin) {
+		t.Errorf("PinMode(20) error = %v, want %v", err, goduino.ErrInvalidPin)
+	}
+	if _, err := arduino.DigitalRead(-1); !errors.Is(err, goduino.ErrInvalidPin) {
+		t.Errorf("DigitalRead(-1) error = %v, want %v", err, goduino.ErrInvalidPin)
+	}
+	if _, err := arduino.PinState(context.Background(), 20); !errors.Is(err, goduino.ErrInvalidPin) {
+		t.Errorf("PinState(20) error = %v, want %v", err, goduino.ErrInvalidPin)
+	}
+}
+
+// deafBoard drops everything the host writes, so the board never answers
+type deafBoard struct {
+	*sim.Board
+}
+
+func (b deafBoard) Write(p []byte) (int, error) { return len(p), nil }
+
+func TestHandshakeDeadline(t *testing.T) {
+	arduino, err := goduino.New("sim", goduino.WithConn(deafBoard{sim.NewUno()}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err = arduino.ConnectContext(ctx)
+	var herr *goduino.HandshakeError
+	if !errors.As(err, &herr) {
+		t.Fatalf("ConnectContext() error = %v, want a HandshakeError", err)
+	}
+	if herr.Stage != firmata.StageVersion || !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("ConnectContext() error = %v, want a protocol version stage deadline", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("ConnectContext() returned after %v", elapsed)
+	}
+	if arduino.State() != goduino.StateDisconnected {
+		t.Errorf("state = %v, want %v", arduino.State(), goduino.StateDisconnected)
+	}
+}
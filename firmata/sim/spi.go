@@ -0,0 +1,93 @@
+package sim
+
+import "github.com/argandas/goduino/firmata"
+
+// SPIHandler simulates an SPI device: it receives the bytes clocked out by
+// the board and returns the bytes clocked back in.
+type SPIHandler func(tx []byte) (rx []byte)
+
+// spiDevice is an SPI device attached to the simulated board
+type spiDevice struct {
+	handler SPIHandler
+	config  *firmata.SPIConfig
+}
+
+// AddSPIDevice attaches handler as device deviceID on SPI channel.
+func (b *Board) AddSPIDevice(channel, deviceID int, handler SPIHandler) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.spi[spiKey(channel, deviceID)] = &spiDevice{handler: handler}
+}
+
+// SPIConfig returns the configuration last sent for the device, and false
+// if it hasn't been configured. CSPin is -1 when the host drives chip
+// select.
+func (b *Board) SPIConfig(channel, deviceID int) (firmata.SPIConfig, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if dev, ok := b.spi[spiKey(channel, deviceID)]; ok && dev.config != nil {
+		return *dev.config, true
+	}
+	return firmata.SPIConfig{}, false
+}
+
+func (b *Board) handleSPI(data []byte) {
+	if len(data) < 2 {
+		return
+	}
+	dev, ok := b.spi[data[1]]
+	if !ok {
+		return
+	}
+	switch firmata.SPISubCommand(data[0]) {
+	case firmata.SPI_DEVICE_CONFIG:
+		if cfg, ok := decodeSPIConfig(data[1:]); ok {
+			dev.config = &cfg
+		}
+	case firmata.SPI_TRANSFER, firmata.SPI_WRITE, firmata.SPI_READ:
+		if len(data) < 5 {
+			return
+		}
+		tx := decode(data[5:])
+		if firmata.SPISubCommand(data[0]) == firmata.SPI_READ {
+			tx = make([]byte, data[4])
+		}
+		rx := dev.handler(tx)
+		if firmata.SPISubCommand(data[0]) == firmata.SPI_WRITE {
+			return
+		}
+		frame := []byte{byte(firmata.SysExSPI), byte(firmata.SPI_REPLY), data[1], data[2], byte(len(rx))}
+		b.sendSysex(append(frame, encode(rx)...)...)
+	}
+}
+
+// decodeSPIConfig decodes the device config frame: device, data mode and
+// bit order, speed in 5 bytes, word size, csPinOptions and, if bit 0 of the
+// options is set, the chip select pin.
+func decodeSPIConfig(data []byte) (firmata.SPIConfig, bool) {
+	if len(data) < 9 {
+		return firmata.SPIConfig{}, false
+	}
+	cfg := firmata.SPIConfig{
+		Channel:  int(data[0] & 0x07),
+		DeviceID: int(data[0]>>3) & 0x0F,
+		BitOrder: int(data[1] & 0x01),
+		DataMode: int(data[1]>>1&0x03) << 2,
+		WordSize: int(data[7]),
+		CSPin:    -1,
+	}
+	for i := 0; i < 5; i++ {
+		cfg.Speed |= int(data[2+i]&0x7F) << (7 * i)
+	}
+	if data[8]&0x01 != 0 {
+		if len(data) < 10 {
+			return firmata.SPIConfig{}, false
+		}
+		cfg.CSPin = int(data[9])
+	}
+	return cfg, true
+}
+
+func spiKey(channel, deviceID int) byte {
+	return byte((deviceID&0x0F)<<3 | channel&0x07)
+}
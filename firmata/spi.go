@@ -0,0 +1,215 @@
+package firmata
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Errors
+var ErrSPIConfig = errors.New("invalid SPI configuration")
+
+// SPIConfig describes a device attached to an SPI channel
+type SPIConfig struct {
+	Channel  int // SPI port of the board, 0-7
+	DeviceID int // id used to address the device in transfers, 0-15
+	CSPin    int // chip select pin driven by the board, negative to drive it yourself
+	BitOrder int // SPI_MSBFIRST or SPI_LSBFIRST
+	DataMode int // SPI_MODE0 to SPI_MODE3
+	Speed    int // maximum clock speed in Hz
+	WordSize int // bits per word, 0 for the default of 8
+}
+
+// SPIReply represents the data returned by an SPI transfer or read
+type SPIReply struct {
+	Channel   int
+	DeviceID  int
+	RequestID int
+	Data      []byte
+}
+
+// spiWaiter is a transaction waiting for its SPIReply
+type spiWaiter struct {
+	channel   int
+	deviceID  int
+	requestID int
+	reply     chan SPIReply
+}
+
+func (cfg SPIConfig) validate() error {
+	switch {
+	case cfg.Channel < 0 || cfg.Channel > 7:
+		return fmt.Errorf("%w: channel %d", ErrSPIConfig, cfg.Channel)
+	case cfg.DeviceID < 0 || cfg.DeviceID > 15:
+		return fmt.Errorf("%w: device id %d", ErrSPIConfig, cfg.DeviceID)
+	case cfg.CSPin > 0x7F:
+		return fmt.Errorf("%w: chip select pin %d", ErrSPIConfig, cfg.CSPin)
+	case cfg.BitOrder != SPI_MSBFIRST && cfg.BitOrder != SPI_LSBFIRST:
+		return fmt.Errorf("%w: bit order %d", ErrSPIConfig, cfg.BitOrder)
+	case cfg.DataMode&^0x0C != 0:
+		return fmt.Errorf("%w: data mode 0x%02X", ErrSPIConfig, cfg.DataMode)
+	case cfg.Speed < 0:
+		return fmt.Errorf("%w: speed %d", ErrSPIConfig, cfg.Speed)
+	case cfg.WordSize < 0 || cfg.WordSize > 0x7F:
+		return fmt.Errorf("%w: word size %d", ErrSPIConfig, cfg.WordSize)
+	}
+	return nil
+}
+
+// spiCSPinControl is the csPinOptions bit telling the board to drive the
+// chip select pin that follows it
+const spiCSPinControl = 0x01
+
+// SPIBegin initializes the SPI channel.
+func (f *Firmata) SPIBegin(channel int) error {
+	return f.writeSysex([]byte{byte(SysExSPI), byte(SPI_BEGIN), byte(channel & 0x07)})
+}
+
+// SPIConfig configures a device on an SPI channel that has been started
+// with SPIBegin.
+func (f *Firmata) SPIConfig(cfg SPIConfig) error {
+	if err := cfg.validate(); err != nil {
+		return err
+	}
+	ret := []byte{
+		byte(SysExSPI),
+		byte(SPI_DEVICE_CONFIG),
+		spiDevice(cfg.Channel, cfg.DeviceID),
+		byte((cfg.DataMode>>2)<<1 | cfg.BitOrder),
+		byte(cfg.Speed & 0x7F),
+		byte((cfg.Speed >> 7) & 0x7F),
+		byte((cfg.Speed >> 14) & 0x7F),
+		byte((cfg.Speed >> 21) & 0x7F),
+		byte((cfg.Speed >> 28) & 0x7F),
+		byte(cfg.WordSize),
+		0, // csPinOptions, bit 0 set when the board drives chip select
+	}
+	if cfg.CSPin >= 0 {
+		ret[len(ret)-1] = spiCSPinControl
+		ret = append(ret, byte(cfg.CSPin))
+	}
+	return f.writeSysex(ret)
+}
+
+// SPITransfer writes data to the device and reads back the same number of
+// bytes, the board answers with an SPIReply carrying requestID. A true
+// deselect releases chip select at the end of the transfer.
+func (f *Firmata) SPITransfer(channel, deviceID, requestID int, data []byte, deselect bool) error {
+	return f.spiRequest(SPI_TRANSFER, channel, deviceID, requestID, deselect, len(data), data)
+}
+
+// SPIWrite writes data to the device without reading.
+func (f *Firmata) SPIWrite(channel, deviceID, requestID int, data []byte, deselect bool) error {
+	return f.spiRequest(SPI_WRITE, channel, deviceID, requestID, deselect, len(data), data)
+}
+
+// SPIRead reads numBytes from the device, the board answers with an SPIReply
+// carrying requestID.
+func (f *Firmata) SPIRead(channel, deviceID, requestID, numBytes int, deselect bool) error {
+	return f.spiRequest(SPI_READ, channel, deviceID, requestID, deselect, numBytes, nil)
+}
+
+// SPIEnd releases the SPI channel.
+func (f *Firmata) SPIEnd(channel int) error {
+	return f.writeSysex([]byte{byte(SysExSPI), byte(SPI_END), byte(channel & 0x07)})
+}
+
+// SPITransferSync runs SPITransfer and waits up to timeout for its reply.
+func (f *Firmata) SPITransferSync(channel, deviceID, requestID int, data []byte, deselect bool, timeout time.Duration) ([]byte, error) {
+	return f.spiSync(channel, deviceID, requestID, timeout, func() error {
+		return f.SPITransfer(channel, deviceID, requestID, data, deselect)
+	})
+}
+
+// SPIReadSync runs SPIRead and waits up to timeout for its reply.
+func (f *Firmata) SPIReadSync(channel, deviceID, requestID, numBytes int, deselect bool, timeout time.Duration) ([]byte, error) {
+	return f.spiSync(channel, deviceID, requestID, timeout, func() error {
+		return f.SPIRead(channel, deviceID, requestID, numBytes, deselect)
+	})
+}
+
+func (f *Firmata) spiRequest(cmd SPISubCommand, channel, deviceID, requestID int, deselect bool, numBytes int, data []byte) error {
+	if requestID < 0 || requestID > 0x7F {
+		return fmt.Errorf("SPI request id %d out of range", requestID)
+	}
+	if numBytes < 0 || numBytes > 0x7F {
+		return fmt.Errorf("SPI transaction of %d bytes is too long", numBytes)
+	}
+	ret := []byte{byte(SysExSPI), byte(cmd), spiDevice(channel, deviceID), byte(requestID), 0, byte(numBytes)}
+	if deselect {
+		ret[4] = 1
+	}
+	for _, val := range data {
+		ret = append(ret, val&0x7F, (val>>7)&0x7F)
+	}
+	return f.writeSysex(ret)
+}
+
+func (f *Firmata) spiSync(channel, deviceID, requestID int, timeout time.Duration, send func() error) ([]byte, error) {
+	w := &spiWaiter{channel: channel, deviceID: deviceID, requestID: requestID, reply: make(chan SPIReply, 1)}
+	f.pendingMu.Lock()
+	f.spiWaiters = append(f.spiWaiters, w)
+	f.pendingMu.Unlock()
+
+	if err := send(); err != nil {
+		f.removeSPIWaiter(w)
+		return nil, err
+	}
+
+	select {
+	case reply := <-w.reply:
+		return reply.Data, nil
+	case <-time.After(timeout):
+		f.removeSPIWaiter(w)
+		return nil, fmt.Errorf("SPI request %d to device %d: %w", requestID, deviceID, ErrTimeout)
+	}
+}
+
+// parseSPI decodes an SPI sysex sent by the board.
+func (f *Firmata) parseSPI(data []byte) {
+	if len(data) < 4 || SPISubCommand(data[0]) != SPI_REPLY {
+		f.emit(ErrorEvent{fmt.Errorf("unexpected SPI message: % X", data)})
+		return
+	}
+	reply := SPIReply{
+		Channel:   int(data[1] & 0x07),
+		DeviceID:  int(data[1] >> 3),
+		RequestID: int(data[2]),
+		Data:      []byte{},
+	}
+	for i := 4; i+1 < len(data); i = i + 2 {
+		reply.Data = append(reply.Data, data[i]|data[i+1]<<7)
+	}
+	f.logger.Debugf("SPIReply%v", reply)
+	f.deliverSPIReply(reply)
+	f.emit(SPIReplyEvent{reply})
+}
+
+// deliverSPIReply hands reply to the transaction waiting for it.
+func (f *Firmata) deliverSPIReply(reply SPIReply) {
+	f.pendingMu.Lock()
+	defer f.pendingMu.Unlock()
+	for i, w := range f.spiWaiters {
+		if w.channel == reply.Channel && w.deviceID == reply.DeviceID && w.requestID == reply.RequestID {
+			f.spiWaiters = append(f.spiWaiters[:i:i], f.spiWaiters[i+1:]...)
+			w.reply <- reply
+			return
+		}
+	}
+}
+
+func (f *Firmata) removeSPIWaiter(w *spiWaiter) {
+	f.pendingMu.Lock()
+	defer f.pendingMu.Unlock()
+	for i, p := range f.spiWaiters {
+		if p == w {
+			f.spiWaiters = append(f.spiWaiters[:i:i], f.spiWaiters[i+1:]...)
+			return
+		}
+	}
+}
+
+// spiDevice packs the device id and channel into one byte.
+func spiDevice(channel, deviceID int) byte {
+	return byte((deviceID&0x0F)<<3 | channel&0x07)
+}
@@ -0,0 +1,302 @@
+package goduino
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/argandas/goduino/firmata"
+)
+
+// Errors
+var (
+	ErrBoardExists  = errors.New("fleet already has a board with this name")
+	ErrUnknownBoard = errors.New("fleet has no board with this name")
+)
+
+// FleetEvent is an event of a board of a Fleet, tagged with the board name
+type FleetEvent struct {
+	Board string
+	Event firmata.Event
+}
+
+// BoardHealth reports the state of a board of a Fleet
+type BoardHealth struct {
+	Name    string
+	Port    string
+	State   ConnState
+	Since   time.Time // time of the last state change, zero if none yet
+	LastErr error     // error of the last state change, if any
+	Dropped uint64    // events the board discarded, see Goduino.Dropped
+}
+
+// FleetError reports the boards an operation of a Fleet failed on. It
+// unwraps to the errors of every board.
+type FleetError struct {
+	Errs map[string]error // error by board name
+}
+
+func (e *FleetError) Error() string {
+	names := make([]string, 0, len(e.Errs))
+	for name := range e.Errs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	msgs := make([]string, len(names))
+	for i, name := range names {
+		msgs[i] = fmt.Sprintf("%s: %v", name, e.Errs[name])
+	}
+	return strings.Join(msgs, "; ")
+}
+
+func (e *FleetError) Unwrap() []error {
+	errs := make([]error, 0, len(e.Errs))
+	for _, err := range e.Errs {
+		errs = append(errs, err)
+	}
+	return errs
+}
+
+// fleetMember is a board of a Fleet along with its bookkeeping
+type fleetMember struct {
+	ino         *Goduino
+	unsubscribe func()
+	stop        chan struct{} // closed to stop forwarding events, nil if not forwarding
+
+	since   time.Time
+	lastErr error
+}
+
+// Fleet manages a set of boards by name. Operations run on all boards in
+// parallel. A Fleet is safe for concurrent use by multiple goroutines.
+type Fleet struct {
+	dropped    uint64 // first for 64-bit alignment of atomic ops
+	mu         sync.Mutex
+	boards     map[string]*fleetMember
+	events     chan FleetEvent
+	forwarders *sync.WaitGroup // forwarders writing to events
+	forwarding bool
+}
+
+// NewFleet returns a Fleet holding boards.
+func NewFleet(boards ...*Goduino) (*Fleet, error) {
+	f := &Fleet{boards: map[string]*fleetMember{}}
+	for _, ino := range boards {
+		if err := f.Add(ino); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+// Add adds ino to the fleet under its Name.
+func (f *Fleet) Add(ino *Goduino) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	name := ino.Name()
+	if _, ok := f.boards[name]; ok {
+		return fmt.Errorf("%w: %q", ErrBoardExists, name)
+	}
+	m := &fleetMember{ino: ino}
+	m.unsubscribe = ino.OnStateChange(func(state ConnState, err error) {
+		f.mu.Lock()
+		m.since = time.Now()
+		m.lastErr = err
+		f.mu.Unlock()
+	})
+	f.boards[name] = m
+	if f.forwarding {
+		f.forward(m)
+	}
+	return nil
+}
+
+// Remove takes the board called name out of the fleet and returns it. The
+// board is left connected.
+func (f *Fleet) Remove(name string) (*Goduino, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	m, ok := f.boards[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownBoard, name)
+	}
+	f.release(m)
+	delete(f.boards, name)
+	return m.ino, nil
+}
+
+// Board returns the board called name.
+func (f *Fleet) Board(name string) (*Goduino, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	m, ok := f.boards[name]
+	if !ok {
+		return nil, false
+	}
+	return m.ino, true
+}
+
+// Names returns the names of the boards, sorted.
+func (f *Fleet) Names() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	names := make([]string, 0, len(f.boards))
+	for name := range f.boards {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Connect connects the boards that aren't connected yet, in parallel. It
+// returns a *FleetError naming the boards that failed; the others stay
+// connected.
+func (f *Fleet) Connect(ctx context.Context) error {
+	return f.Do(ctx, func(ctx context.Context, ino *Goduino) error {
+		if ino.State() != StateDisconnected {
+			return nil
+		}
+		return ino.ConnectContext(ctx)
+	})
+}
+
+// Disconnect disconnects every board in parallel.
+func (f *Fleet) Disconnect() error {
+	return f.Do(context.Background(), func(ctx context.Context, ino *Goduino) error {
+		return ino.Disconnect()
+	})
+}
+
+// Do calls fn for every board in parallel and waits for all calls to
+// return. It returns a *FleetError holding the errors of the boards fn
+// failed on.
+func (f *Fleet) Do(ctx context.Context, fn func(ctx context.Context, ino *Goduino) error) error {
+	f.mu.Lock()
+	boards := make(map[string]*Goduino, len(f.boards))
+	for name, m := range f.boards {
+		boards[name] = m.ino
+	}
+	f.mu.Unlock()
+
+	var mu sync.Mutex
+	errs := map[string]error{}
+	var wg sync.WaitGroup
+	for name, ino := range boards {
+		wg.Add(1)
+		go func(name string, ino *Goduino) {
+			defer wg.Done()
+			if err := fn(ctx, ino); err != nil {
+				mu.Lock()
+				errs[name] = err
+				mu.Unlock()
+			}
+		}(name, ino)
+	}
+	wg.Wait()
+	if len(errs) > 0 {
+		return &FleetError{Errs: errs}
+	}
+	return nil
+}
+
+// Health returns the state of every board, sorted by name.
+func (f *Fleet) Health() []BoardHealth {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	health := make([]BoardHealth, 0, len(f.boards))
+	for name, m := range f.boards {
+		health = append(health, BoardHealth{
+			Name:    name,
+			Port:    m.ino.Port(),
+			State:   m.ino.State(),
+			Since:   m.since,
+			LastErr: m.lastErr,
+			Dropped: m.ino.Dropped(),
+		})
+	}
+	sort.Slice(health, func(i, j int) bool { return health[i].Name < health[j].Name })
+	return health
+}
+
+// Events returns the events of all boards merged in one stream, tagged
+// with the board name. The first call starts reading the Events of every
+// board, so they should no longer be read directly. The channel holds up to
+// firmata.EventBufferSize events; events that don't fit are dropped and
+// counted by Dropped. Close closes the channel.
+func (f *Fleet) Events() <-chan FleetEvent {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !f.forwarding {
+		f.forwarding = true
+		f.events = make(chan FleetEvent, firmata.EventBufferSize)
+		f.forwarders = &sync.WaitGroup{}
+		for _, m := range f.boards {
+			f.forward(m)
+		}
+	}
+	return f.events
+}
+
+// Dropped returns the number of events discarded because the Events buffer
+// was full.
+func (f *Fleet) Dropped() uint64 {
+	return atomic.LoadUint64(&f.dropped)
+}
+
+// Close stops merging events and watching the boards, and closes the Events
+// channel once the last merged event is in. The boards are left connected,
+// see Disconnect.
+func (f *Fleet) Close() {
+	f.mu.Lock()
+	for _, m := range f.boards {
+		f.release(m)
+	}
+	events, forwarders := f.events, f.forwarders
+	f.events, f.forwarders = nil, nil
+	f.forwarding = false
+	f.mu.Unlock()
+	if events != nil {
+		forwarders.Wait()
+		close(events)
+	}
+}
+
+// forward copies the events of m to the merged stream until m.stop is
+// closed.
+func (f *Fleet) forward(m *fleetMember) {
+	m.stop = make(chan struct{})
+	f.forwarders.Add(1)
+	go func(name string, events <-chan firmata.Event, out chan<- FleetEvent, stop chan struct{}, wg *sync.WaitGroup) {
+		defer wg.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			case e := <-events:
+				select {
+				case out <- FleetEvent{Board: name, Event: e}:
+				default:
+					atomic.AddUint64(&f.dropped, 1)
+				}
+			}
+		}
+	}(m.ino.Name(), m.ino.Events(), f.events, m.stop, f.forwarders)
+}
+
+// release stops watching m.
+func (f *Fleet) release(m *fleetMember) {
+	if m.stop != nil {
+		close(m.stop)
+		m.stop = nil
+	}
+	if m.unsubscribe != nil {
+		m.unsubscribe()
+		m.unsubscribe = nil
+	}
+}
@@ -0,0 +1,130 @@
+package goduino
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/argandas/goduino/firmata"
+	"github.com/argandas/goduino/firmata/sim"
+)
+
+func newTestBoard(t *testing.T, name string) (*Goduino, *sim.Board) {
+	t.Helper()
+	board := sim.NewUno()
+	arduino, err := New(name, WithConn(board))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return arduino, board
+}
+
+func TestFleet(t *testing.T) {
+	left, _ := newTestBoard(t, "left")
+	right, rightBoard := newTestBoard(t, "right")
+	// Nothing listens on a port just closed
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln.Close()
+	conveyor, err := New("conveyor", WithPort("tcp://"+ln.Addr().String()), WithHandshakeTimeout(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fleet, err := NewFleet(left, right, conveyor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fleet.Close()
+	err = fleet.Connect(context.Background())
+	var fe *FleetError
+	if !errors.As(err, &fe) || len(fe.Errs) != 1 || fe.Errs["conveyor"] == nil {
+		t.Fatalf("Connect() = %v, want an error for conveyor only", err)
+	}
+
+	health := fleet.Health()
+	if len(health) != 3 {
+		t.Fatalf("Health() = %+v", health)
+	}
+	for _, h := range health {
+		want := StateConnected
+		if h.Name == "conveyor" {
+			want = StateDisconnected
+		}
+		if h.State != want {
+			t.Errorf("%s is %v, want %v", h.Name, h.State, want)
+		}
+	}
+
+	events := fleet.Events()
+	err = fleet.Do(context.Background(), func(ctx context.Context, ino *Goduino) error {
+		if ino.Name() == "conveyor" {
+			return nil
+		}
+		return ino.PinMode(2, Input)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rightBoard.SetDigital(2, 1)
+	timeout := time.After(2 * time.Second)
+	for received := false; !received; {
+		select {
+		case e := <-events:
+			_, digital := e.Event.(firmata.DigitalEvent)
+			received = digital && e.Board == "right"
+		case <-timeout:
+			t.Fatal("no digital event from right")
+		}
+	}
+
+	// conveyor never opened its port
+	if err := fleet.Disconnect(); err != nil {
+		t.Fatal(err)
+	}
+	for _, h := range fleet.Health() {
+		if h.State != StateDisconnected {
+			t.Errorf("%s is %v after Disconnect", h.Name, h.State)
+		}
+	}
+
+	// Close ends a range over Events
+	fleet.Close()
+	timeout = time.After(time.Second)
+	for open := true; open; {
+		select {
+		case _, open = <-events:
+		case <-timeout:
+			t.Fatal("Events not closed by Close")
+		}
+	}
+}
+
+func TestFleetNames(t *testing.T) {
+	a, _ := newTestBoard(t, "a")
+	b, _ := newTestBoard(t, "b")
+	if _, err := NewFleet(a, b, a); !errors.Is(err, ErrBoardExists) {
+		t.Fatalf("NewFleet() = %v, want ErrBoardExists", err)
+	}
+	fleet, err := NewFleet(b, a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fleet.Close()
+	if names := fleet.Names(); len(names) != 2 || names[0] != "a" || names[1] != "b" {
+		t.Errorf("Names() = %v", names)
+	}
+	if ino, err := fleet.Remove("a"); err != nil || ino != a {
+		t.Errorf("Remove() = %v, %v", ino, err)
+	}
+	if _, err := fleet.Remove("a"); !errors.Is(err, ErrUnknownBoard) {
+		t.Errorf("Remove() = %v, want ErrUnknownBoard", err)
+	}
+	if _, ok := fleet.Board("b"); !ok {
+		t.Error("Board(b) not found")
+	}
+}
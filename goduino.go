@@ -1,28 +1,67 @@
 package goduino
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"github.com/argandas/goduino/firmata"
-	"github.com/tarm/serial"
 	"io"
-	"log"
-	"os"
+	"sync"
 	"time"
 )
 
 const (
-	Input  = firmata.Input
-	Output = firmata.Output
-	Analog = firmata.Analog
-	Pwm    = firmata.Pwm
-	Servo  = firmata.Servo
+	Input   = firmata.Input
+	Output  = firmata.Output
+	Analog  = firmata.Analog
+	Pwm     = firmata.Pwm
+	Servo   = firmata.Servo
+	Shift   = firmata.Shift
+	I2C     = firmata.I2C
+	OneWire = firmata.OneWire
+	Stepper = firmata.Stepper
+	Encoder = firmata.Encoder
+	Uart    = firmata.Uart
+	Pullup  = firmata.Pullup
+	Ignore  = firmata.Ignore
 )
 
+// I2cReply represents the data returned by an I2C read
+type I2cReply = firmata.I2cReply
+
+// PinState represents the mode and state of a pin as reported by the board
+type PinState = firmata.PinState
+
+// I2CRequestOptions controls addressing and restart of an I2C request
+type I2CRequestOptions = firmata.I2CRequestOptions
+
+// Logger receives the leveled diagnostic messages of Goduino
+type Logger = firmata.Logger
+
+// Tracer receives every frame exchanged with the board
+type Tracer = firmata.Tracer
+
+// HandshakeConfig controls how long Connect waits for the board
+type HandshakeConfig = firmata.HandshakeConfig
+
+// HandshakeError reports the handshake stage at which Connect failed
+type HandshakeError = firmata.HandshakeError
+
+// ErrInvalidPin is returned for pins the board doesn't have
+var ErrInvalidPin = firmata.ErrInvalidPin
+
+// ErrAnalogChannel is returned for analog channels the board doesn't have
+var ErrAnalogChannel = errors.New("board has no such analog channel")
+
 type firmataBoard interface {
-	Connect(io.ReadWriteCloser) error
+	ConnectContext(context.Context, io.ReadWriteCloser) error
+	SetHandshake(firmata.HandshakeConfig)
 	Disconnect() error
 	Pins() []firmata.Pin
+	Pin(int) (firmata.Pin, bool)
+	AnalogPins() []int
 	AnalogWrite(int, int) error
+	Resolution(int, int) int
 	SetPinMode(int, int) error
 	ReportAnalog(int, int) error
 	ReportDigital(int, int) error
@@ -30,65 +69,226 @@ type firmataBoard interface {
 	I2cRead(int, int) error
 	I2cWrite(int, []byte) error
 	I2cConfig(int) error
+	ServoConfig(int, int, int) error
+	SetSamplingInterval(time.Duration) error
+	SamplingInterval() time.Duration
+	I2cWriteRequest(int, []byte, firmata.I2CRequestOptions) error
+	I2cReadSync(int, int, firmata.I2CRequestOptions, time.Duration) ([]byte, error)
+	I2cSubscribe(int, int, firmata.I2CRequestOptions) (<-chan firmata.I2cReply, func() error, error)
+	I2cContinuousReadRequest(int, int, firmata.I2CRequestOptions) error
+	SPIBegin(int) error
+	SPIConfig(firmata.SPIConfig) error
+	SPIWrite(int, int, int, []byte, bool) error
+	SPITransferSync(int, int, int, []byte, bool, time.Duration) ([]byte, error)
+	SPIReadSync(int, int, int, int, bool, time.Duration) ([]byte, error)
+	SPIEnd(int) error
+	ConfigureSerial(firmata.SerialPort, int, int, int) error
+	WriteSerial(firmata.SerialPort, []byte) error
+	ReadSerial(firmata.SerialPort, byte, int) error
+	CloseSerial(firmata.SerialPort) error
+	OnSerialData(firmata.SerialPort, func([]byte))
+	OnPinChange(firmata.PinChangeFunc)
+	OnDisconnect(func(error))
+	Events() <-chan firmata.Event
+	Dropped() uint64
+	QueryPinState(context.Context, int) (firmata.PinState, error)
 }
 
-// Arduino Firmata client for golang
+// Arduino Firmata client for golang. A Goduino is safe for concurrent use by
+// multiple goroutines.
 type Goduino struct {
-	name    string
-	port    string
-	board   firmataBoard
-	conn    io.ReadWriteCloser
-	openSP  func(port string) (io.ReadWriteCloser, error)
-	logger  *log.Logger
-	verbose bool
-}
-
-// Creates a new Goduino object and connects to the Arduino board
-// over specified serial port. This function blocks till a connection is
-// succesfullt established and pin mappings are retrieved.
-func New(name string, args ...interface{}) *Goduino {
-	// Create new Goduino client
-	goduino := &Goduino{
-		name:  name,
-		port:  "",
-		conn:  nil,
-		board: firmata.New(),
-		openSP: func(port string) (io.ReadWriteCloser, error) {
-			return serial.OpenPort(&serial.Config{Name: port, Baud: 57600})
-		},
-		logger:  log.New(os.Stdout, fmt.Sprintf("[%s] ", name), log.Ltime),
-		verbose: true,
-	}
-	// Parse variadic args
+	name   string
+	port   string
+	board  firmataBoard
+	conn   io.ReadWriteCloser
+	openSP func(ctx context.Context, port string) (io.ReadWriteCloser, error)
+	serial SerialConfig
+	dialer Dialer
+	logger Logger
+
+	match       portMatch
+	discovery   Discovery
+	foundSerial string // USB serial number of the board found by firmware
+
+	i2cEnabled bool
+
+	spiDevices   map[int]SPIConfig
+	spiRequestID int
+
+	serials map[SerialPort]*serialStream
+	servos  map[int]servoRange
+
+	mu              sync.Mutex
+	listeners       map[int][]*pinListener
+	analogListeners map[int][]*pinListener
+	i2cSubs         map[int]*i2cSub
+	nextID          int
+
+	modes          map[int]int
+	outputs        map[int]int
+	reconnect      ReconnectConfig
+	state          ConnState
+	stateListeners map[int]func(ConnState, error)
+	done           chan struct{}
+}
+
+// New creates a Goduino object for an Arduino board, configured by the
+// Options in args:
+//
+//	arduino, err := goduino.New("myArduino", goduino.WithPort("/dev/ttyACM0"),
+//		goduino.WithBaud(115200), goduino.WithHandshakeTimeout(10*time.Second))
+//
+// For compatibility args may also hold the serial port, tcp://host:port or
+// udp://host:port address or io.ReadWriteCloser as plain values, as in
+// New("myArduino", "COM1"). Invalid options and arguments of other types
+// return an error wrapping ErrOption.
+//
+// Instead of a port, WithSerialNumber and WithFirmware select the board
+// among the ports found by Discover when connecting.
+func New(name string, args ...interface{}) (*Goduino, error) {
+	opts := options{
+		serial:    SerialConfig{Baud: DefaultBaud},
+		dialer:    DefaultDialer,
+		logger:    firmata.Discard,
+		discovery: DefaultDiscovery,
+	}
 	for _, arg := range args {
-		switch arg.(type) {
-		case string:
-			goduino.port = arg.(string)
-		case io.ReadWriteCloser:
-			goduino.conn = arg.(io.ReadWriteCloser)
+		if err := argOption(arg)(&opts); err != nil {
+			return nil, err
 		}
 	}
-	return goduino
+	if opts.port != "" && opts.conn != nil {
+		return nil, fmt.Errorf("%w: both a port and a connection given", ErrOption)
+	}
+	if opts.match != (portMatch{}) && (opts.port != "" || opts.conn != nil) {
+		return nil, fmt.Errorf("%w: board selected by both port and serial number or firmware", ErrOption)
+	}
+	// Create new Goduino client
+	goduino := &Goduino{
+		name:            name,
+		port:            opts.port,
+		conn:            opts.conn,
+		serial:          opts.serial,
+		dialer:          opts.dialer,
+		logger:          opts.logger,
+		match:           opts.match,
+		discovery:       opts.discovery,
+		listeners:       map[int][]*pinListener{},
+		analogListeners: map[int][]*pinListener{},
+		i2cSubs:         map[int]*i2cSub{},
+		spiDevices:      map[int]SPIConfig{},
+		serials:         map[SerialPort]*serialStream{},
+		servos:          map[int]servoRange{},
+		modes:           map[int]int{},
+		outputs:         map[int]int{},
+		reconnect:       DefaultReconnect,
+		stateListeners:  map[int]func(ConnState, error){},
+	}
+	goduino.openSP = goduino.open
+	goduino.board = firmata.New(firmata.WithLogger(goduino.logger), firmata.WithTracer(opts.tracer))
+	if opts.handshake != nil {
+		goduino.board.SetHandshake(*opts.handshake)
+	}
+	goduino.board.OnPinChange(goduino.pinChanged)
+	goduino.board.OnDisconnect(goduino.linkLost)
+	return goduino, nil
 }
 
 // Connect starts a connection to the firmata board.
 func (ino *Goduino) Connect() error {
-	if ino.conn == nil {
+	return ino.ConnectContext(context.Background())
+}
+
+// ConnectContext starts a connection to the firmata board, giving up when ctx
+// is done or the handshake times out. A failed handshake returns a
+// *HandshakeError naming the stage the board didn't answer. If the link is
+// lost later the port is reopened as configured by SetReconnect.
+func (ino *Goduino) ConnectContext(ctx context.Context) error {
+	if err := ino.connect(ctx, nil); err != nil {
+		return err
+	}
+	ino.mu.Lock()
+	ino.done = make(chan struct{})
+	ino.mu.Unlock()
+	ino.setState(StateConnected, nil)
+	return nil
+}
+
+// connect opens the port if needed and runs the firmata handshake. When
+// reconnecting, done is the channel of the connection being restored and
+// connect fails with errDisconnected if Disconnect closed it meanwhile.
+func (ino *Goduino) connect(ctx context.Context, done chan struct{}) error {
+	ino.mu.Lock()
+	conn := ino.conn
+	ino.mu.Unlock()
+	opened := false
+	if conn == nil && ino.match != (portMatch{}) {
+		// The board may have moved since the last connect
+		port, err := ino.findPort(ctx)
+		if err != nil {
+			return err
+		}
+		ino.mu.Lock()
+		ino.port = port
+		ino.mu.Unlock()
+	}
+	if conn == nil {
 		// Try to connect to serial port
-		sp, err := ino.openSP(ino.Port())
+		sp, err := ino.openSP(ctx, ino.Port())
 		if err != nil {
 			return err
 		}
 		// Serial connection was successful
-		ino.conn = sp
+		conn = sp
+		opened = true
 	}
 	// Firmata connection
-	return ino.board.Connect(ino.conn)
+	if err := ino.board.ConnectContext(ctx, conn); err != nil {
+		// The board closed the port, it is opened again on the next attempt
+		return err
+	}
+	ino.mu.Lock()
+	if done != nil && ino.done != done {
+		ino.mu.Unlock()
+		ino.board.Disconnect()
+		return errDisconnected
+	}
+	if opened {
+		ino.conn = conn
+	}
+	ino.mu.Unlock()
+	if opened {
+		holdPort(ino, ino.Port())
+	}
+	return nil
+}
+
+// SetHandshake sets the timeouts used by Connect, see
+// firmata.DefaultHandshake.
+func (ino *Goduino) SetHandshake(cfg HandshakeConfig) {
+	ino.board.SetHandshake(cfg)
 }
 
 // Disconnect closes the io connection to the firmata board
 func (ino *Goduino) Disconnect() (err error) {
+	// Stop reconnecting
+	ino.mu.Lock()
+	if ino.done != nil {
+		close(ino.done)
+		ino.done = nil
+	}
+	if ino.port != "" {
+		// The board closes the port we opened, Connect opens it again
+		ino.conn = nil
+	}
+	ino.mu.Unlock()
+	releasePort(ino)
+	defer ino.setState(StateDisconnected, nil)
 	if ino.board != nil {
+		// Stop continuous I2C reads
+		ino.i2cStopAll()
+		// Close serial passthrough streams
+		ino.serialCloseAll()
 		// Disconnect firmata board
 		return ino.board.Disconnect()
 	}
@@ -96,36 +296,48 @@ func (ino *Goduino) Disconnect() (err error) {
 }
 
 // Port returns the  FirmataAdaptors port
-func (ino *Goduino) Port() string { return ino.port }
+func (ino *Goduino) Port() string {
+	ino.mu.Lock()
+	defer ino.mu.Unlock()
+	return ino.port
+}
 
 // Name returns the  FirmataAdaptors name
 func (ino *Goduino) Name() string { return ino.name }
 
 // PinMode configures the specified pin to behave either as an input or an output.
+// Input and Pullup pins are reported by the board whenever their value changes.
 func (ino *Goduino) PinMode(pin, mode int) error {
 	// Check if pin is valid
-	if uint8(pin) < 0 || pin > len(ino.board.Pins()) {
-		return fmt.Errorf("Invalid pin number %v\n", pin)
+	if pin < 0 || pin >= len(ino.board.Pins()) {
+		return fmt.Errorf("%w: %d", ErrInvalidPin, pin)
 	}
 	switch mode {
-	// If mode == Input
-	case Input:
+	// If mode == Input or Pullup
+	case Input, Pullup:
 		// Set pin mode
 		if err := ino.board.SetPinMode(pin, mode); err != nil {
 			return err
 		}
-		if err := ino.board.ReportDigital(pin, 1); err != nil {
+		// Digital reporting is enabled per port of 8 pins
+		if err := ino.board.ReportDigital(pin/8, 1); err != nil {
 			return err
 		}
 		<-time.After(10 * time.Millisecond)
 	// If mode == Analog
 	case Analog:
-		pin = ino.digitalPin(pin)
+		channel := pin
+		p, err := ino.A(channel)
+		if err != nil {
+			return err
+		}
+		pin = p
 		// Set pin mode
 		if err := ino.board.SetPinMode(pin, mode); err != nil {
 			return err
 		}
-		if err := ino.board.ReportAnalog(pin, 1); err != nil {
+		// Analog reporting is enabled per channel, not per pin
+		if err := ino.board.ReportAnalog(channel, 1); err != nil {
 			return err
 		}
 		<-time.After(10 * time.Millisecond)
@@ -136,19 +348,82 @@ func (ino *Goduino) PinMode(pin, mode int) error {
 		}
 	}
 	// PinMode was successful
-	ino.logger.Printf("pinMode(%d, %s)\r\n", pin, PinMode(mode))
+	ino.recordMode(pin, mode)
+	ino.logger.Debugf("pinMode(%d, %s)", pin, PinMode(mode))
+	return nil
+}
+
+// PinState asks the board for the mode and state of pin and waits for the
+// answer until ctx is done. The local pin cache is updated with the result.
+func (ino *Goduino) PinState(ctx context.Context, pin int) (PinState, error) {
+	if pin < 0 || pin >= len(ino.board.Pins()) {
+		return PinState{}, fmt.Errorf("%w: %d", ErrInvalidPin, pin)
+	}
+	return ino.board.QueryPinState(ctx, pin)
+}
+
+// SyncPins refreshes the mode and state of every pin from the board, so the
+// local pin cache matches a board that was configured before connecting.
+func (ino *Goduino) SyncPins(ctx context.Context) error {
+	for pin := range ino.board.Pins() {
+		if _, err := ino.board.QueryPinState(ctx, pin); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
+// SetSamplingInterval sets how often the board reports analog values and
+// continuous I2C reads, from 1ms to about 16s in whole milliseconds.
+func (ino *Goduino) SetSamplingInterval(d time.Duration) error {
+	if err := ino.board.SetSamplingInterval(d); err != nil {
+		return err
+	}
+	ino.logger.Debugf("samplingInterval(%v)", d)
+	return nil
+}
+
+// SamplingInterval returns the sampling interval of the board.
+func (ino *Goduino) SamplingInterval() time.Duration {
+	return ino.board.SamplingInterval()
+}
+
 // Close the serial connection to properly clean up after ourselves
 // Usage: defer client.Close()
 func (ino *Goduino) Delay(duration time.Duration) {
 	time.Sleep(duration)
 }
 
-// digitalPin converts pin number to digital mapping
-func (ino *Goduino) digitalPin(pin int) int {
-	return pin + 14
+// pin returns a snapshot of pin, or an error if the board has no such pin.
+func (ino *Goduino) pin(pin int) (firmata.Pin, error) {
+	p, ok := ino.board.Pin(pin)
+	if !ok {
+		return p, fmt.Errorf("%w: %d", ErrInvalidPin, pin)
+	}
+	return p, nil
+}
+
+// checkMode returns an error if pin doesn't exist or doesn't support mode
+// according to the capability response of the board.
+func (ino *Goduino) checkMode(pin, mode int) error {
+	pins := ino.board.Pins()
+	if pin < 0 || pin >= len(pins) {
+		return fmt.Errorf("%w: %d", ErrInvalidPin, pin)
+	}
+	if _, ok := pins[pin].SupportedModes[mode]; ok {
+		return nil
+	}
+	return fmt.Errorf("pin %d does not support %s mode", pin, PinMode(mode))
+}
+
+// A returns the digital pin number of analog channel n, according to the
+// analog mapping reported by the board.
+func (ino *Goduino) A(n int) (int, error) {
+	analogPins := ino.board.AnalogPins()
+	if n < 0 || n >= len(analogPins) || analogPins[n] < 0 {
+		return 0, fmt.Errorf("%w: A%d", ErrAnalogChannel, n)
+	}
+	return analogPins[n], nil
 }
 
 type PinMode uint8
@@ -165,6 +440,22 @@ func (m PinMode) String() string {
 		return "PWM"
 	case m == Servo:
 		return "SERVO"
+	case m == Shift:
+		return "SHIFT"
+	case m == I2C:
+		return "I2C"
+	case m == OneWire:
+		return "ONEWIRE"
+	case m == Stepper:
+		return "STEPPER"
+	case m == Encoder:
+		return "ENCODER"
+	case m == Uart:
+		return "SERIAL"
+	case m == Pullup:
+		return "PULLUP"
+	case m == Ignore:
+		return "IGNORE"
 	}
 	return "UNKNOWN"
 }
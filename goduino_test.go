@@ -0,0 +1,121 @@
+package goduino
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/argandas/goduino/firmata"
+	"github.com/argandas/goduino/firmata/sim"
+)
+
+// connectSim connects a Goduino to board and disconnects it when the test
+// ends.
+func connectSim(t *testing.T, board *sim.Board) *Goduino {
+	t.Helper()
+	arduino, err := New("test", WithConn(board))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := arduino.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { arduino.Disconnect() })
+	return arduino
+}
+
+func TestPinState(t *testing.T) {
+	board := sim.NewUno()
+	arduino, err := New("test", WithConn(board))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := arduino.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer arduino.Disconnect()
+
+	if err := arduino.DigitalWrite(13, 1); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	state, err := arduino.PinState(ctx, 13)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state != (PinState{Pin: 13, Mode: Output, State: 1}) {
+		t.Errorf("PinState(13) = %+v, want output 1", state)
+	}
+	if state, err := arduino.PinState(ctx, 14); err != nil || state.Mode != Analog {
+		t.Errorf("PinState(14) = %+v, %v, want analog", state, err)
+	}
+}
+
+func TestSyncPins(t *testing.T) {
+	// Outputs left set by an earlier session
+	board := sim.NewUno()
+	board.SetDigital(7, 1)
+	board.SetDigital(3, 200)
+	arduino, err := New("test", WithConn(board))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := arduino.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer arduino.Disconnect()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := arduino.SyncPins(ctx); err != nil {
+		t.Fatal(err)
+	}
+	for pin, want := range map[int]int{3: 200, 7: 1, 8: 0} {
+		p, err := arduino.pin(pin)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p.Mode != Output || p.Value != want {
+			t.Errorf("pin %d = mode %d value %d, want output %d", pin, p.Mode, p.Value, want)
+		}
+	}
+	if p, _ := arduino.pin(15); p.Mode != Analog {
+		t.Errorf("pin 15 mode = %d, want analog", p.Mode)
+	}
+}
+
+func TestSamplingInterval(t *testing.T) {
+	board := sim.NewUno()
+	arduino := connectSim(t, board)
+	if err := arduino.SetSamplingInterval(0); !errors.Is(err, firmata.ErrInterval) {
+		t.Errorf("SetSamplingInterval(0) error = %v, want %v", err, firmata.ErrInterval)
+	}
+	if err := arduino.SetSamplingInterval(5 * time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if d := board.SamplingInterval(); d != 5*time.Millisecond {
+		t.Errorf("board sampling interval = %v, want 5ms", d)
+	}
+	if d := arduino.SamplingInterval(); d != 5*time.Millisecond {
+		t.Errorf("SamplingInterval() = %v, want 5ms", d)
+	}
+	if err := arduino.PinMode(2, Analog); err != nil {
+		t.Fatal(err)
+	}
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case e := <-arduino.Events():
+			if e, ok := e.(firmata.AnalogEvent); ok {
+				if e.Interval != 5*time.Millisecond {
+					t.Errorf("AnalogEvent interval = %v, want 5ms", e.Interval)
+				}
+				return
+			}
+		case <-timeout:
+			t.Fatal("no AnalogEvent")
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package goduino
+
+import "time"
+
+// I2CRead reads n bytes from register of the I2C device at addr and waits
+// up to timeout for the data. A negative register reads from the register
+// the device currently points to.
+func (ino *Goduino) I2CRead(addr, register, n int, timeout time.Duration) ([]byte, error) {
+	return ino.I2CReadWithOptions(addr, n, registerOptions(register), timeout)
+}
+
+// I2CReadWithOptions reads n bytes from the I2C device at addr using opts
+// and waits up to timeout for the data.
+func (ino *Goduino) I2CReadWithOptions(addr, n int, opts I2CRequestOptions, timeout time.Duration) ([]byte, error) {
+	if err := ino.i2cEnable(); err != nil {
+		return nil, err
+	}
+	data, err := ino.board.I2cReadSync(addr, n, opts, timeout)
+	if err != nil {
+		return nil, err
+	}
+	ino.logger.Debugf("i2cRead(0x%02X, %d, %d) -> % X", addr, opts.Register, n, data)
+	return data, nil
+}
+
+// I2CWrite writes data to register of the I2C device at addr. A negative
+// register writes data as is.
+func (ino *Goduino) I2CWrite(addr, register int, data []byte) error {
+	return ino.I2CWriteWithOptions(addr, data, registerOptions(register))
+}
+
+// I2CWriteWithOptions writes data to the I2C device at addr using opts.
+func (ino *Goduino) I2CWriteWithOptions(addr int, data []byte, opts I2CRequestOptions) error {
+	if err := ino.i2cEnable(); err != nil {
+		return err
+	}
+	ino.logger.Debugf("i2cWrite(0x%02X, %d, % X)", addr, opts.Register, data)
+	return ino.board.I2cWriteRequest(addr, data, opts)
+}
+
+// registerOptions returns the default request options for register, a
+// negative register is left out of the request.
+func registerOptions(register int) I2CRequestOptions {
+	return I2CRequestOptions{HasRegister: register >= 0, Register: register}
+}
+
+// i2cEnable sends the I2C configuration before the first I2C request.
+func (ino *Goduino) i2cEnable() error {
+	ino.mu.Lock()
+	enabled := ino.i2cEnabled
+	ino.mu.Unlock()
+	if enabled {
+		return nil
+	}
+	if err := ino.board.I2cConfig(0); err != nil {
+		return err
+	}
+	ino.mu.Lock()
+	ino.i2cEnabled = true
+	ino.mu.Unlock()
+	return nil
+}
+
+// i2cSub is a continuous read, kept to restart it on reconnect
+type i2cSub struct {
+	addr, n int
+	opts    I2CRequestOptions
+	stop    func() error
+}
+
+// I2CSubscribe starts a continuous read of n bytes from register of the I2C
+// device at addr. Every reply is sent to the returned channel until cancel
+// is called or the board is disconnected, which stops the read on the board
+// and closes the channel.
+func (ino *Goduino) I2CSubscribe(addr, register, n int) (<-chan I2cReply, func(), error) {
+	return ino.I2CSubscribeWithOptions(addr, n, registerOptions(register))
+}
+
+// I2CSubscribeWithOptions is like I2CSubscribe but sends the continuous read
+// using opts.
+func (ino *Goduino) I2CSubscribeWithOptions(addr, n int, opts I2CRequestOptions) (<-chan I2cReply, func(), error) {
+	if err := ino.i2cEnable(); err != nil {
+		return nil, nil, err
+	}
+	replies, stop, err := ino.board.I2cSubscribe(addr, n, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	ino.logger.Debugf("i2cSubscribe(0x%02X, %d, %d)", addr, opts.Register, n)
+
+	ino.mu.Lock()
+	ino.nextID++
+	id := ino.nextID
+	ino.i2cSubs[id] = &i2cSub{addr: addr, n: n, opts: opts, stop: stop}
+	ino.mu.Unlock()
+
+	cancel := func() {
+		ino.mu.Lock()
+		delete(ino.i2cSubs, id)
+		ino.mu.Unlock()
+		stop()
+	}
+	return replies, cancel, nil
+}
+
+// i2cStopAll cancels every I2C subscription.
+func (ino *Goduino) i2cStopAll() {
+	ino.mu.Lock()
+	subs := ino.i2cSubs
+	ino.i2cSubs = map[int]*i2cSub{}
+	ino.mu.Unlock()
+	for _, sub := range subs {
+		sub.stop()
+	}
+}
@@ -0,0 +1,76 @@
+package goduino
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/argandas/goduino/firmata"
+	"github.com/argandas/goduino/firmata/sim"
+)
+
+func TestI2CReadWrite(t *testing.T) {
+	board := sim.NewUno()
+	dev := board.AddI2CDevice(0x68, 16)
+	copy(dev.Registers[4:], []byte{0xDE, 0xAD, 0xBE})
+	arduino := connectSim(t, board)
+
+	data, err := arduino.I2CRead(0x68, 4, 3, time.Second)
+	if err != nil || !bytes.Equal(data, []byte{0xDE, 0xAD, 0xBE}) {
+		t.Fatalf("I2CRead() = % X, %v, want DE AD BE", data, err)
+	}
+	if err := arduino.I2CWrite(0x68, 1, []byte{0xAA, 0xFF}); err != nil {
+		t.Fatal(err)
+	}
+	data, err = arduino.I2CRead(0x68, 1, 2, time.Second)
+	if err != nil || !bytes.Equal(data, []byte{0xAA, 0xFF}) {
+		t.Fatalf("I2CRead() after write = % X, %v, want AA FF", data, err)
+	}
+	// Nothing answers at 0x50
+	if _, err := arduino.I2CRead(0x50, 1, 2, 50*time.Millisecond); !errors.Is(err, firmata.ErrTimeout) {
+		t.Errorf("I2CRead(0x50) error = %v, want %v", err, firmata.ErrTimeout)
+	}
+}
+
+func TestI2CSubscribe(t *testing.T) {
+	board := sim.NewUno()
+	dev := board.AddI2CDevice(0x68, 16)
+	copy(dev.Registers[4:], []byte{1, 2})
+	arduino, err := New("test", WithConn(board))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := arduino.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer arduino.Disconnect()
+
+	replies, cancel, err := arduino.I2CSubscribe(0x68, 4, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		select {
+		case reply := <-replies:
+			if reply.Register != 4 || string(reply.Data) != "\x01\x02" {
+				t.Fatalf("reply = %+v, want register 4 data 01 02", reply)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("no I2C reply")
+		}
+	}
+	cancel()
+	for range replies {
+	}
+}
+
+func TestI2CSubscribeNotConnected(t *testing.T) {
+	arduino, err := New("test", WithConn(sim.NewUno()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := arduino.I2CSubscribe(0x68, 4, 2); !errors.Is(err, firmata.ErrNotConnected) {
+		t.Errorf("I2CSubscribe() error = %v, want %v", err, firmata.ErrNotConnected)
+	}
+}
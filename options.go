@@ -0,0 +1,255 @@
+package goduino
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/argandas/goduino/firmata"
+	"github.com/tarm/serial"
+)
+
+// ErrOption is wrapped by the errors of invalid options
+var ErrOption = errors.New("invalid option")
+
+// DefaultBaud is the baud rate of StandardFirmata
+const DefaultBaud = 57600
+
+// Parity of the serial line
+type Parity = serial.Parity
+
+// Parity settings
+const (
+	ParityNone  = serial.ParityNone
+	ParityOdd   = serial.ParityOdd
+	ParityEven  = serial.ParityEven
+	ParityMark  = serial.ParityMark
+	ParitySpace = serial.ParitySpace
+)
+
+// StopBits of the serial line
+type StopBits = serial.StopBits
+
+// Stop bit settings
+const (
+	Stop1     = serial.Stop1
+	Stop1Half = serial.Stop1Half
+	Stop2     = serial.Stop2
+)
+
+// SerialConfig holds the settings of the host serial port the board is
+// attached to. Zero fields keep their default. A read that times out is
+// retried, it isn't taken for a lost link; the timeout only bounds how long
+// a read blocks on drivers where closing the port doesn't interrupt it.
+type SerialConfig struct {
+	Baud        int           // DefaultBaud if 0
+	Size        byte          // data bits, 8 if 0
+	Parity      Parity        // ParityNone if 0
+	StopBits    StopBits      // Stop1 if 0
+	ReadTimeout time.Duration // bound on each read, 0 blocks until data arrives
+}
+
+// options collects the settings given to New
+type options struct {
+	port      string
+	conn      io.ReadWriteCloser
+	serial    SerialConfig
+	dialer    Dialer
+	logger    Logger
+	tracer    Tracer
+	handshake *HandshakeConfig
+	match     portMatch
+	discovery Discovery
+}
+
+// Option configures a Goduino created by New.
+type Option func(*options) error
+
+// WithPort connects to the serial port or tcp:// or udp:// address port.
+func WithPort(port string) Option {
+	return func(o *options) error {
+		if port == "" {
+			return fmt.Errorf("%w: empty port", ErrOption)
+		}
+		o.port = port
+		return nil
+	}
+}
+
+// WithConn talks to the board over conn instead of opening a port.
+func WithConn(conn io.ReadWriteCloser) Option {
+	return func(o *options) error {
+		if conn == nil {
+			return fmt.Errorf("%w: nil connection", ErrOption)
+		}
+		o.conn = conn
+		return nil
+	}
+}
+
+// WithBaud sets the baud rate of the serial port.
+func WithBaud(baud int) Option {
+	return func(o *options) error {
+		if baud <= 0 {
+			return fmt.Errorf("%w: baud rate %d", ErrOption, baud)
+		}
+		o.serial.Baud = baud
+		return nil
+	}
+}
+
+// WithSerialConfig sets the baud rate, data bits, parity, stop bits and read
+// timeout of the serial port.
+func WithSerialConfig(cfg SerialConfig) Option {
+	return func(o *options) error {
+		switch {
+		case cfg.Baud < 0:
+			return fmt.Errorf("%w: baud rate %d", ErrOption, cfg.Baud)
+		case cfg.Size != 0 && (cfg.Size < 5 || cfg.Size > 8):
+			return fmt.Errorf("%w: %d data bits", ErrOption, cfg.Size)
+		case cfg.ReadTimeout < 0:
+			return fmt.Errorf("%w: read timeout %v", ErrOption, cfg.ReadTimeout)
+		}
+		switch cfg.Parity {
+		case 0, ParityNone, ParityOdd, ParityEven, ParityMark, ParitySpace:
+		default:
+			return fmt.Errorf("%w: parity %q", ErrOption, byte(cfg.Parity))
+		}
+		switch cfg.StopBits {
+		case 0, Stop1, Stop1Half, Stop2:
+		default:
+			return fmt.Errorf("%w: stop bits %d", ErrOption, cfg.StopBits)
+		}
+		if cfg.Baud == 0 {
+			cfg.Baud = o.serial.Baud
+		}
+		o.serial = cfg
+		return nil
+	}
+}
+
+// WithSerialNumber connects to the USB board with serial number sn,
+// wherever it is attached. The port is looked up again on every connect.
+func WithSerialNumber(sn string) Option {
+	return func(o *options) error {
+		if sn == "" {
+			return fmt.Errorf("%w: empty serial number", ErrOption)
+		}
+		o.match.serialNumber = sn
+		return nil
+	}
+}
+
+// WithFirmware connects to the first board whose firmware is called name,
+// as reported by probing the ports one at a time. The port is looked up
+// again on every connect.
+//
+// Probing opens the port, which resets most Arduino boards and drops
+// anything they were doing. Ports held by other connected boards are never
+// probed, and once found the board is recognized by its USB serial number
+// so later connects probe again only if it is gone. Combine it with
+// WithSerialNumber to probe a single port.
+func WithFirmware(name string) Option {
+	return func(o *options) error {
+		if name == "" {
+			return fmt.Errorf("%w: empty firmware name", ErrOption)
+		}
+		o.match.firmware = name
+		return nil
+	}
+}
+
+// WithDiscovery sets where WithSerialNumber and WithFirmware look for the
+// board, DefaultDiscovery by default.
+func WithDiscovery(d Discovery) Option {
+	return func(o *options) error {
+		if d.SysfsRoot == "" || d.DevDir == "" {
+			return fmt.Errorf("%w: discovery without sysfs root or device directory", ErrOption)
+		}
+		o.discovery = d
+		return nil
+	}
+}
+
+// WithDialer opens tcp:// and udp:// ports with d instead of DefaultDialer.
+func WithDialer(d Dialer) Option {
+	return func(o *options) error {
+		if d == nil {
+			return fmt.Errorf("%w: nil dialer", ErrOption)
+		}
+		o.dialer = d
+		return nil
+	}
+}
+
+// WithLogger sends the messages of Goduino and the firmata client to l.
+func WithLogger(l Logger) Option {
+	return func(o *options) error {
+		if l == nil {
+			return fmt.Errorf("%w: nil logger", ErrOption)
+		}
+		o.logger = l
+		return nil
+	}
+}
+
+// WithTracer hands every frame exchanged with the board to t.
+func WithTracer(t Tracer) Option {
+	return func(o *options) error {
+		if t == nil {
+			return fmt.Errorf("%w: nil tracer", ErrOption)
+		}
+		o.tracer = t
+		return nil
+	}
+}
+
+// WithHandshakeTimeout limits the whole handshake run by Connect to d.
+func WithHandshakeTimeout(d time.Duration) Option {
+	return func(o *options) error {
+		if d <= 0 {
+			return fmt.Errorf("%w: handshake timeout %v", ErrOption, d)
+		}
+		cfg := o.handshakeConfig()
+		cfg.Timeout = d
+		o.handshake = &cfg
+		return nil
+	}
+}
+
+// WithHandshake sets every handshake timeout, see SetHandshake.
+func WithHandshake(cfg HandshakeConfig) Option {
+	return func(o *options) error {
+		if cfg.StageTimeout < 0 || cfg.ResetInterval < 0 || cfg.Timeout < 0 || cfg.QueryRetries < 0 {
+			return fmt.Errorf("%w: negative handshake setting %+v", ErrOption, cfg)
+		}
+		o.handshake = &cfg
+		return nil
+	}
+}
+
+func (o *options) handshakeConfig() HandshakeConfig {
+	if o.handshake != nil {
+		return *o.handshake
+	}
+	return firmata.DefaultHandshake
+}
+
+// argOption turns an argument of New into an Option. Besides Options, New
+// takes the port and connection as plain values.
+func argOption(arg interface{}) Option {
+	return func(o *options) error {
+		switch arg := arg.(type) {
+		case Option:
+			return arg(o)
+		case string:
+			o.port = arg
+		case io.ReadWriteCloser:
+			o.conn = arg
+		default:
+			return fmt.Errorf("%w: unsupported argument of type %T", ErrOption, arg)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,34 @@
+package goduino
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/argandas/goduino/firmata"
+	"github.com/argandas/goduino/firmata/sim"
+)
+
+func TestNewArguments(t *testing.T) {
+	good := [][]interface{}{
+		{"COM1"},
+		{sim.NewUno()},
+		{WithPort("COM1"), WithLogger(firmata.Discard), WithTracer(firmata.HexDump(nil))},
+	}
+	for _, args := range good {
+		if _, err := New("test", args...); err != nil {
+			t.Errorf("New(%v) error = %v", args, err)
+		}
+	}
+	bad := [][]interface{}{
+		{42},
+		{"COM1", sim.NewUno()},
+		{firmata.Discard},
+		{DefaultDialer},
+		{WithLogger(nil)},
+	}
+	for _, args := range bad {
+		if _, err := New("test", args...); !errors.Is(err, ErrOption) {
+			t.Errorf("New(%v) error = %v, want %v", args, err, ErrOption)
+		}
+	}
+}
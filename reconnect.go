@@ -0,0 +1,293 @@
+package goduino
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/argandas/goduino/firmata"
+)
+
+// errDisconnected is returned by connect when Disconnect is called while
+// reconnecting
+var errDisconnected = errors.New("disconnected while reconnecting")
+
+// ConnState is the state of the link to the board
+type ConnState int
+
+// Connection states
+const (
+	StateDisconnected ConnState = iota
+	StateConnected
+	StateReconnecting
+)
+
+func (s ConnState) String() string {
+	switch s {
+	case StateDisconnected:
+		return "DISCONNECTED"
+	case StateConnected:
+		return "CONNECTED"
+	case StateReconnecting:
+		return "RECONNECTING"
+	}
+	return "UNKNOWN"
+}
+
+// ReconnectConfig controls how the port is reopened after the link to the
+// board is lost. The wait between attempts starts at MinBackoff and doubles
+// up to MaxBackoff.
+type ReconnectConfig struct {
+	Disabled   bool
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+}
+
+// DefaultReconnect retries twice a second at first and every 30 seconds at
+// most.
+var DefaultReconnect = ReconnectConfig{
+	MinBackoff: 500 * time.Millisecond,
+	MaxBackoff: 30 * time.Second,
+}
+
+// SetReconnect sets how the port is reopened when the link is lost. Boards
+// connected through an io.ReadWriteCloser passed to New can't be reopened
+// and go straight to StateDisconnected.
+func (ino *Goduino) SetReconnect(cfg ReconnectConfig) {
+	ino.mu.Lock()
+	ino.reconnect = cfg
+	ino.mu.Unlock()
+}
+
+// State returns the state of the link to the board.
+func (ino *Goduino) State() ConnState {
+	ino.mu.Lock()
+	defer ino.mu.Unlock()
+	return ino.state
+}
+
+// OnStateChange calls fn every time the link to the board changes state,
+// with the error that caused it if any. While the state is
+// StateReconnecting calls to the board fail and control loops should pause.
+// The returned function removes the callback.
+func (ino *Goduino) OnStateChange(fn func(state ConnState, err error)) (unsubscribe func()) {
+	ino.mu.Lock()
+	defer ino.mu.Unlock()
+	ino.nextID++
+	id := ino.nextID
+	ino.stateListeners[id] = fn
+	return func() {
+		ino.mu.Lock()
+		delete(ino.stateListeners, id)
+		ino.mu.Unlock()
+	}
+}
+
+// setState records state and notifies the state callbacks.
+func (ino *Goduino) setState(state ConnState, err error) {
+	ino.changeState(nil, state, err)
+}
+
+// changeState is setState for the connection of done, it does nothing if
+// Disconnect has ended that connection. A nil done always applies.
+func (ino *Goduino) changeState(done chan struct{}, state ConnState, err error) {
+	ino.mu.Lock()
+	if done != nil && ino.done != done {
+		ino.mu.Unlock()
+		return
+	}
+	if ino.state == state {
+		ino.mu.Unlock()
+		return
+	}
+	ino.state = state
+	calls := []func(ConnState, error){}
+	for _, fn := range ino.stateListeners {
+		calls = append(calls, fn)
+	}
+	ino.mu.Unlock()
+	ino.logger.Infof("connection %v", state)
+	for _, fn := range calls {
+		fn(state, err)
+	}
+}
+
+// recordMode remembers the mode of pin so it can be restored on reconnect.
+func (ino *Goduino) recordMode(pin, mode int) {
+	ino.mu.Lock()
+	defer ino.mu.Unlock()
+	if old, ok := ino.modes[pin]; ok && old != mode {
+		delete(ino.outputs, pin)
+	}
+	ino.modes[pin] = mode
+}
+
+// recordOutput remembers the value last written to pin in mode so it can be
+// restored on reconnect. Pins start out as outputs, so a write may be the
+// first time a mode is recorded for pin.
+func (ino *Goduino) recordOutput(pin, mode, value int) {
+	ino.mu.Lock()
+	ino.modes[pin] = mode
+	ino.outputs[pin] = value
+	ino.mu.Unlock()
+}
+
+// linkLost is called by the board when the connection drops.
+func (ino *Goduino) linkLost(err error) {
+	ino.mu.Lock()
+	cfg := ino.reconnect
+	done := ino.done
+	port := ino.port
+	ino.mu.Unlock()
+	if cfg.Disabled || port == "" || done == nil {
+		releasePort(ino)
+		ino.setState(StateDisconnected, err)
+		return
+	}
+	ino.setState(StateReconnecting, err)
+	go ino.reopen(cfg, done)
+}
+
+// reopen retries the connection with backoff until it succeeds or done is
+// closed by Disconnect, then restores the recorded configuration.
+func (ino *Goduino) reopen(cfg ReconnectConfig, done chan struct{}) {
+	ino.mu.Lock()
+	if ino.conn != nil {
+		ino.conn.Close()
+		ino.conn = nil
+	}
+	ino.mu.Unlock()
+	backoff := cfg.MinBackoff
+	for attempt := 1; ; attempt++ {
+		select {
+		case <-done:
+			return
+		case <-time.After(backoff):
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		go func() {
+			select {
+			case <-done:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+		err := ino.connect(ctx, done)
+		cancel()
+		if err == nil {
+			if err := ino.replay(); err != nil {
+				ino.logger.Errorf("restoring configuration: %v", err)
+			}
+			ino.changeState(done, StateConnected, nil)
+			return
+		}
+		if errors.Is(err, errDisconnected) {
+			return
+		}
+		ino.logger.Warnf("reconnect attempt %d: %v", attempt, err)
+
+		if backoff *= 2; backoff > cfg.MaxBackoff {
+			backoff = cfg.MaxBackoff
+		}
+	}
+}
+
+// replay restores the pin modes, reporting, servo, I2C and SPI
+// configuration, the continuous I2C reads, the serial ports and the last
+// written outputs on a board that was just reconnected.
+func (ino *Goduino) replay() error {
+	ino.mu.Lock()
+	modes := map[int]int{}
+	for pin, mode := range ino.modes {
+		modes[pin] = mode
+	}
+	outputs := map[int]int{}
+	for pin, value := range ino.outputs {
+		outputs[pin] = value
+	}
+	servos := map[int]servoRange{}
+	for pin, r := range ino.servos {
+		servos[pin] = r
+	}
+	spiDevices := []SPIConfig{}
+	for _, cfg := range ino.spiDevices {
+		spiDevices = append(spiDevices, cfg)
+	}
+	i2cEnabled := ino.i2cEnabled
+	i2cSubs := []*i2cSub{}
+	for _, sub := range ino.i2cSubs {
+		i2cSubs = append(i2cSubs, sub)
+	}
+	serials := []*serialStream{}
+	for _, s := range ino.serials {
+		serials = append(serials, s)
+	}
+	ino.mu.Unlock()
+
+	if d := ino.board.SamplingInterval(); d != firmata.DefaultSamplingInterval {
+		if err := ino.board.SetSamplingInterval(d); err != nil {
+			return err
+		}
+	}
+	pins := ino.board.Pins()
+	for pin, mode := range modes {
+		if pin >= len(pins) {
+			return fmt.Errorf("%w: %d", ErrInvalidPin, pin)
+		}
+		var err error
+		switch r, ok := servos[pin]; {
+		case mode == Servo && ok:
+			err = ino.ServoAttach(pin, r.min, r.max)
+		case mode == Analog:
+			// PinMode takes the analog channel
+			err = ino.PinMode(pins[pin].AnalogChannel, Analog)
+		default:
+			err = ino.PinMode(pin, mode)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	for pin, value := range outputs {
+		var err error
+		switch modes[pin] {
+		case Output:
+			err = ino.board.DigitalWrite(pin, value)
+		case Pwm, Servo:
+			err = ino.board.AnalogWrite(pin, value)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if i2cEnabled {
+		if err := ino.board.I2cConfig(0); err != nil {
+			return err
+		}
+	}
+	for _, sub := range i2cSubs {
+		if err := ino.board.I2cContinuousReadRequest(sub.addr, sub.n, sub.opts); err != nil {
+			return err
+		}
+	}
+	for _, s := range serials {
+		if err := s.configure(); err != nil {
+			return err
+		}
+	}
+	begun := map[int]bool{}
+	for _, cfg := range spiDevices {
+		if !begun[cfg.Channel] {
+			if err := ino.board.SPIBegin(cfg.Channel); err != nil {
+				return err
+			}
+			begun[cfg.Channel] = true
+		}
+		if err := ino.board.SPIConfig(cfg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
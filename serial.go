@@ -0,0 +1,161 @@
+package goduino
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/argandas/goduino/firmata"
+)
+
+// SerialPort identifies a serial port of the board
+type SerialPort = firmata.SerialPort
+
+// Serial ports
+const (
+	SoftSerial  = firmata.SoftSerial
+	HardSerial1 = firmata.HardSerial1
+	HardSerial2 = firmata.HardSerial2
+	HardSerial3 = firmata.HardSerial3
+)
+
+// serialChunk is the number of bytes sent per SerialWrite message, so that
+// each message fits the 64 byte sysex buffer of StandardFirmata.
+const serialChunk = 28
+
+// ErrSerialClosed is returned by reads and writes on a closed serial stream
+var ErrSerialClosed = errors.New("serial port is closed")
+
+// serialStream is an io.ReadWriteCloser backed by Serial sysex messages
+type serialStream struct {
+	ino          *Goduino
+	port         SerialPort
+	baud         int
+	rxPin, txPin int
+
+	mu     sync.Mutex
+	cond   *sync.Cond
+	buf    []byte
+	closed bool
+}
+
+// OpenSerial opens a serial port of the board at baud and returns a stream
+// that writes to it and reads what it receives. rxPin and txPin are only
+// used by SoftSerial.
+func (ino *Goduino) OpenSerial(port SerialPort, baud, rxPin, txPin int) (io.ReadWriteCloser, error) {
+	ino.mu.Lock()
+	if _, ok := ino.serials[port]; ok {
+		ino.mu.Unlock()
+		return nil, fmt.Errorf("serial port 0x%02X is already open", byte(port))
+	}
+	s := &serialStream{ino: ino, port: port, baud: baud, rxPin: rxPin, txPin: txPin}
+	s.cond = sync.NewCond(&s.mu)
+	ino.serials[port] = s
+	ino.mu.Unlock()
+
+	ino.board.OnSerialData(port, s.receive)
+	if err := s.configure(); err != nil {
+		s.release()
+		return nil, err
+	}
+	ino.logger.Debugf("openSerial(0x%02X, %d)", byte(port), baud)
+	return s, nil
+}
+
+// configure opens the port on the board and starts reading it.
+func (s *serialStream) configure() error {
+	if err := s.ino.board.ConfigureSerial(s.port, s.baud, s.rxPin, s.txPin); err != nil {
+		return err
+	}
+	return s.ino.board.ReadSerial(s.port, firmata.SerialReadContinuous, 0)
+}
+
+// Read reads data received on the serial port, blocking until some is
+// available or the stream is closed.
+func (s *serialStream) Read(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for len(s.buf) == 0 && !s.closed {
+		s.cond.Wait()
+	}
+	if len(s.buf) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(p, s.buf)
+	s.buf = s.buf[n:]
+	return n, nil
+}
+
+// Write sends p out of the serial port.
+func (s *serialStream) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	closed := s.closed
+	s.mu.Unlock()
+	if closed {
+		return 0, ErrSerialClosed
+	}
+	n := 0
+	for n < len(p) {
+		end := n + serialChunk
+		if end > len(p) {
+			end = len(p)
+		}
+		if err := s.ino.board.WriteSerial(s.port, p[n:end]); err != nil {
+			return n, err
+		}
+		n = end
+	}
+	return n, nil
+}
+
+// Close stops reading and closes the serial port on the board.
+func (s *serialStream) Close() error {
+	if !s.release() {
+		return nil
+	}
+	if err := s.ino.board.ReadSerial(s.port, firmata.SerialStopReading, 0); err != nil {
+		return err
+	}
+	return s.ino.board.CloseSerial(s.port)
+}
+
+// receive queues data read by the board.
+func (s *serialStream) receive(data []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.buf = append(s.buf, data...)
+	s.cond.Broadcast()
+}
+
+// release marks the stream closed and unregisters it, it returns false if
+// the stream was already closed.
+func (s *serialStream) release() bool {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return false
+	}
+	s.closed = true
+	s.cond.Broadcast()
+	s.mu.Unlock()
+
+	s.ino.board.OnSerialData(s.port, nil)
+	s.ino.mu.Lock()
+	delete(s.ino.serials, s.port)
+	s.ino.mu.Unlock()
+	return true
+}
+
+// serialCloseAll closes every open serial stream.
+func (ino *Goduino) serialCloseAll() {
+	ino.mu.Lock()
+	streams := []*serialStream{}
+	for _, s := range ino.serials {
+		streams = append(streams, s)
+	}
+	ino.mu.Unlock()
+	for _, s := range streams {
+		s.Close()
+	}
+}
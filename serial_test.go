@@ -0,0 +1,52 @@
+package goduino
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/argandas/goduino/firmata/sim"
+)
+
+func TestSerial(t *testing.T) {
+	board := sim.NewUno()
+	arduino := connectSim(t, board)
+	port, err := arduino.OpenSerial(SoftSerial, 9600, 10, 11)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if baud := board.SerialBaud(SoftSerial); baud != 9600 {
+		t.Errorf("baud = %d, want 9600", baud)
+	}
+	if _, err := arduino.OpenSerial(SoftSerial, 9600, 10, 11); err == nil {
+		t.Error("OpenSerial() succeeded on a port already open")
+	}
+
+	// Longer than a single Serial message
+	msg := bytes.Repeat([]byte("$GPGGA,"), 10)
+	if _, err := port.Write(msg); err != nil {
+		t.Fatal(err)
+	}
+	if out := board.SerialOutput(SoftSerial); !bytes.Equal(out, msg) {
+		t.Errorf("board received %q, want %q", out, msg)
+	}
+	board.SerialInput(SoftSerial, []byte("hello\xff"))
+	buf := make([]byte, 6)
+	if _, err := io.ReadFull(port, buf); err != nil || string(buf) != "hello\xff" {
+		t.Errorf("Read() = %q, %v, want \"hello\\xff\"", buf, err)
+	}
+
+	if err := port.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := port.Read(buf); err != io.EOF {
+		t.Errorf("Read() after Close = %v, want io.EOF", err)
+	}
+	if _, err := port.Write(msg); !errors.Is(err, ErrSerialClosed) {
+		t.Errorf("Write() after Close = %v, want %v", err, ErrSerialClosed)
+	}
+	if baud := board.SerialBaud(SoftSerial); baud != 0 {
+		t.Errorf("port still open on the board at %d baud", baud)
+	}
+}
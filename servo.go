@@ -0,0 +1,98 @@
+package goduino
+
+import "fmt"
+
+// MinServoPulse is the shortest pulse ServoWriteMicroseconds can send.
+// StandardFirmata takes smaller values written to a servo as angles.
+const MinServoPulse = 544
+
+// servoRange is the pulse width range a servo was attached with
+type servoRange struct {
+	min, max int
+}
+
+// ServoAttach configures pin to drive a servo with pulses from minUs to
+// maxUs microseconds, which map to 0 and 180 degrees. minUs can't be below
+// MinServoPulse.
+func (ino *Goduino) ServoAttach(pin, minUs, maxUs int) error {
+	if err := ino.checkMode(pin, Servo); err != nil {
+		return err
+	}
+	if minUs < MinServoPulse || maxUs <= minUs || maxUs > 0x3FFF {
+		return fmt.Errorf("invalid servo pulse range %d-%d us, must be within %d-%d us", minUs, maxUs, MinServoPulse, 0x3FFF)
+	}
+	if err := ino.board.ServoConfig(pin, minUs, maxUs); err != nil {
+		return err
+	}
+	if err := ino.board.SetPinMode(pin, Servo); err != nil {
+		return err
+	}
+	ino.mu.Lock()
+	ino.servos[pin] = servoRange{minUs, maxUs}
+	ino.mu.Unlock()
+	ino.recordMode(pin, Servo)
+	ino.logger.Debugf("servoAttach(%d, %d, %d)", pin, minUs, maxUs)
+	return nil
+}
+
+// ServoWrite moves the servo on pin to angle, from 0 to 180 degrees.
+func (ino *Goduino) ServoWrite(pin, angle int) error {
+	if _, err := ino.servo(pin); err != nil {
+		return err
+	}
+	if angle < 0 || angle > 180 {
+		return fmt.Errorf("servo angle %d out of range 0-180", angle)
+	}
+	ino.logger.Debugf("servoWrite(%d, %d)", pin, angle)
+	if err := ino.board.AnalogWrite(pin, angle); err != nil {
+		return err
+	}
+	ino.recordOutput(pin, Servo, angle)
+	return nil
+}
+
+// ServoWriteMicroseconds sends pulses of us microseconds to the servo on
+// pin. us must be within the range the servo was attached with.
+func (ino *Goduino) ServoWriteMicroseconds(pin, us int) error {
+	r, err := ino.servo(pin)
+	if err != nil {
+		return err
+	}
+	if us < r.min || us > r.max {
+		return fmt.Errorf("servo pulse %d us out of range %d-%d us", us, r.min, r.max)
+	}
+	ino.logger.Debugf("servoWriteMicroseconds(%d, %d)", pin, us)
+	if err := ino.board.AnalogWrite(pin, us); err != nil {
+		return err
+	}
+	ino.recordOutput(pin, Servo, us)
+	return nil
+}
+
+// ServoDetach stops driving the servo on pin and returns the pin to a
+// digital output.
+func (ino *Goduino) ServoDetach(pin int) error {
+	if _, err := ino.servo(pin); err != nil {
+		return err
+	}
+	ino.mu.Lock()
+	delete(ino.servos, pin)
+	ino.mu.Unlock()
+	ino.logger.Debugf("servoDetach(%d)", pin)
+	if err := ino.board.SetPinMode(pin, Output); err != nil {
+		return err
+	}
+	ino.recordMode(pin, Output)
+	return nil
+}
+
+// servo returns the pulse range of the servo attached to pin.
+func (ino *Goduino) servo(pin int) (servoRange, error) {
+	ino.mu.Lock()
+	defer ino.mu.Unlock()
+	r, ok := ino.servos[pin]
+	if !ok {
+		return r, fmt.Errorf("no servo attached to pin %d", pin)
+	}
+	return r, nil
+}
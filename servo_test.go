@@ -0,0 +1,33 @@
+package goduino
+
+import (
+	"testing"
+
+	"github.com/argandas/goduino/firmata/sim"
+)
+
+func TestServoPulseRange(t *testing.T) {
+	board := sim.NewUno()
+	arduino, err := New("test", WithConn(board))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := arduino.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer arduino.Disconnect()
+
+	// Firmata would take pulses below 544 us as angles
+	if err := arduino.ServoAttach(9, 400, 2600); err == nil {
+		t.Error("ServoAttach accepted a 400 us minimum")
+	}
+	if err := arduino.ServoAttach(9, MinServoPulse, 2400); err != nil {
+		t.Fatal(err)
+	}
+	if err := arduino.ServoWriteMicroseconds(9, 500); err == nil {
+		t.Error("ServoWriteMicroseconds accepted 500 us")
+	}
+	if err := arduino.ServoWriteMicroseconds(9, 1500); err != nil {
+		t.Fatal(err)
+	}
+}
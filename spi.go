@@ -0,0 +1,92 @@
+package goduino
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/argandas/goduino/firmata"
+)
+
+// SPIConfig describes a device attached to an SPI channel of the board
+type SPIConfig = firmata.SPIConfig
+
+// SPIBegin starts the SPI channel of cfg, if it isn't running yet, and
+// configures the device. Later transactions address the device by
+// cfg.DeviceID.
+func (ino *Goduino) SPIBegin(cfg SPIConfig) error {
+	ino.mu.Lock()
+	begun := false
+	for _, dev := range ino.spiDevices {
+		if dev.Channel == cfg.Channel {
+			begun = true
+		}
+	}
+	ino.mu.Unlock()
+	if !begun {
+		if err := ino.board.SPIBegin(cfg.Channel); err != nil {
+			return err
+		}
+	}
+	if err := ino.board.SPIConfig(cfg); err != nil {
+		return err
+	}
+	ino.mu.Lock()
+	ino.spiDevices[cfg.DeviceID] = cfg
+	ino.mu.Unlock()
+	ino.logger.Debugf("spiBegin(%d, %d)", cfg.Channel, cfg.DeviceID)
+	return nil
+}
+
+// SPITransfer writes data to the device and returns the bytes clocked in
+// during the transfer, waiting up to timeout for the board to answer.
+func (ino *Goduino) SPITransfer(deviceID int, data []byte, timeout time.Duration) ([]byte, error) {
+	cfg, id, err := ino.spiRequest(deviceID)
+	if err != nil {
+		return nil, err
+	}
+	return ino.board.SPITransferSync(cfg.Channel, deviceID, id, data, true, timeout)
+}
+
+// SPIWrite writes data to the device, discarding the bytes clocked in.
+func (ino *Goduino) SPIWrite(deviceID int, data []byte) error {
+	cfg, id, err := ino.spiRequest(deviceID)
+	if err != nil {
+		return err
+	}
+	return ino.board.SPIWrite(cfg.Channel, deviceID, id, data, true)
+}
+
+// SPIRead reads n bytes from the device, waiting up to timeout for the board
+// to answer.
+func (ino *Goduino) SPIRead(deviceID, n int, timeout time.Duration) ([]byte, error) {
+	cfg, id, err := ino.spiRequest(deviceID)
+	if err != nil {
+		return nil, err
+	}
+	return ino.board.SPIReadSync(cfg.Channel, deviceID, id, n, true, timeout)
+}
+
+// SPIEnd releases the SPI channel and forgets the devices configured on it.
+func (ino *Goduino) SPIEnd(channel int) error {
+	ino.mu.Lock()
+	for id, dev := range ino.spiDevices {
+		if dev.Channel == channel {
+			delete(ino.spiDevices, id)
+		}
+	}
+	ino.mu.Unlock()
+	return ino.board.SPIEnd(channel)
+}
+
+// spiRequest returns the configuration of deviceID and the id of the next
+// transaction.
+func (ino *Goduino) spiRequest(deviceID int) (SPIConfig, int, error) {
+	ino.mu.Lock()
+	defer ino.mu.Unlock()
+	cfg, ok := ino.spiDevices[deviceID]
+	if !ok {
+		return cfg, 0, fmt.Errorf("SPI device %d is not configured", deviceID)
+	}
+	ino.spiRequestID = (ino.spiRequestID + 1) & 0x7F
+	return cfg, ino.spiRequestID, nil
+}
@@ -0,0 +1,80 @@
+package goduino
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/argandas/goduino/firmata"
+	"github.com/argandas/goduino/firmata/sim"
+)
+
+func TestSPI(t *testing.T) {
+	board := sim.NewUno()
+	board.AddSPIDevice(0, 3, func(tx []byte) []byte {
+		rx := make([]byte, len(tx))
+		for i := range tx {
+			rx[i] = tx[i] + 1
+		}
+		return rx
+	})
+	arduino, err := New("test", WithConn(board))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := arduino.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer arduino.Disconnect()
+
+	want := SPIConfig{
+		DeviceID: 3,
+		CSPin:    10,
+		BitOrder: firmata.SPI_MSBFIRST,
+		DataMode: firmata.SPI_MODE3,
+		Speed:    4000000,
+		WordSize: 8,
+	}
+	if err := arduino.SPIBegin(want); err != nil {
+		t.Fatal(err)
+	}
+	rx, err := arduino.SPITransfer(3, []byte{1, 0xFE}, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(rx, []byte{2, 0xFF}) {
+		t.Errorf("SPITransfer() = % X, want 02 FF", rx)
+	}
+	if got, ok := board.SPIConfig(0, 3); !ok || got != want {
+		t.Errorf("board config = %+v, want %+v", got, want)
+	}
+}
+
+func TestSPIHostChipSelect(t *testing.T) {
+	board := sim.NewUno()
+	board.AddSPIDevice(1, 2, func(tx []byte) []byte { return tx })
+	arduino, err := New("test", WithConn(board))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := arduino.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer arduino.Disconnect()
+
+	want := SPIConfig{Channel: 1, DeviceID: 2, CSPin: -1, BitOrder: firmata.SPI_LSBFIRST, Speed: 1000000}
+	if err := arduino.SPIBegin(want); err != nil {
+		t.Fatal(err)
+	}
+	if err := arduino.SPIWrite(2, []byte{7}); err != nil {
+		t.Fatal(err)
+	}
+	// The write is answered by nothing, a read makes sure the config was
+	// handled
+	if _, err := arduino.SPIRead(2, 1, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if got, ok := board.SPIConfig(1, 2); !ok || got != want {
+		t.Errorf("board config = %+v, want %+v", got, want)
+	}
+}
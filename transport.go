@@ -0,0 +1,91 @@
+package goduino
+
+import (
+	"context"
+	"io"
+	"net"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/tarm/serial"
+)
+
+// DefaultTCPPort is the port StandardFirmataWiFi and StandardFirmataEthernet
+// listen on, used when a tcp:// address has none.
+const DefaultTCPPort = "3030"
+
+// Dialer opens network connections to boards. A *net.Dialer can be passed
+// to New to set the connect timeout and keepalive period.
+type Dialer interface {
+	DialContext(ctx context.Context, network, address string) (net.Conn, error)
+}
+
+// DefaultDialer gives up connecting after 10 seconds and probes idle
+// connections every 30 seconds, so a board that drops off the network is
+// detected and reconnected.
+var DefaultDialer Dialer = &net.Dialer{
+	Timeout:   10 * time.Second,
+	KeepAlive: 30 * time.Second,
+}
+
+// open opens the connection to port. Ports starting with tcp:// or udp://
+// are dialed with the Dialer of ino, anything else is a serial port.
+func (ino *Goduino) open(ctx context.Context, port string) (io.ReadWriteCloser, error) {
+	if address := strings.TrimPrefix(port, "tcp://"); address != port {
+		return ino.dialer.DialContext(ctx, "tcp", withDefaultPort(address, DefaultTCPPort))
+	}
+	if address := strings.TrimPrefix(port, "udp://"); address != port {
+		conn, err := ino.dialer.DialContext(ctx, "udp", withDefaultPort(address, DefaultUDPPort))
+		if err != nil {
+			return nil, err
+		}
+		return newUDPConn(conn), nil
+	}
+	sp, err := serial.OpenPort(&serial.Config{
+		Name:        port,
+		Baud:        ino.serial.Baud,
+		Size:        ino.serial.Size,
+		Parity:      ino.serial.Parity,
+		StopBits:    ino.serial.StopBits,
+		ReadTimeout: ino.serial.ReadTimeout,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if ino.serial.ReadTimeout > 0 {
+		return &timeoutPort{ReadWriteCloser: sp}, nil
+	}
+	return sp, nil
+}
+
+// timeoutPort wraps a serial port opened with a read timeout. A read that
+// times out returns no data and io.EOF, which isn't the end of the link:
+// timeoutPort reads again until data arrives or the port is closed.
+type timeoutPort struct {
+	io.ReadWriteCloser
+	closed int32
+}
+
+func (p *timeoutPort) Read(b []byte) (int, error) {
+	for {
+		n, err := p.ReadWriteCloser.Read(b)
+		if n == 0 && (err == nil || err == io.EOF) && atomic.LoadInt32(&p.closed) == 0 {
+			continue
+		}
+		return n, err
+	}
+}
+
+func (p *timeoutPort) Close() error {
+	atomic.StoreInt32(&p.closed, 1)
+	return p.ReadWriteCloser.Close()
+}
+
+// withDefaultPort appends port to address if it has none.
+func withDefaultPort(address, port string) string {
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		return net.JoinHostPort(strings.Trim(address, "[]"), port)
+	}
+	return address
+}
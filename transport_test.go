@@ -0,0 +1,253 @@
+package goduino
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/argandas/goduino/firmata/sim"
+)
+
+// serveTCP bridges every connection accepted on ln to a new simulated
+// board, sent on boards.
+func serveTCP(ln net.Listener, boards chan<- *sim.Board) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		board := sim.NewUno()
+		boards <- board
+		go func() { io.Copy(board, conn); board.Close() }()
+		go func() { io.Copy(conn, board); conn.Close() }()
+	}
+}
+
+// syncPin waits for the board to have handled the frames sent before.
+func syncPin(t *testing.T, arduino *Goduino, pin int) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if _, err := arduino.PinState(ctx, pin); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTCPReconnect(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	boards := make(chan *sim.Board, 4)
+	go serveTCP(ln, boards)
+
+	arduino, err := New("tcp", WithPort("tcp://"+ln.Addr().String()),
+		WithDialer(&net.Dialer{Timeout: time.Second, KeepAlive: time.Second}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	arduino.SetReconnect(ReconnectConfig{MinBackoff: 10 * time.Millisecond, MaxBackoff: 50 * time.Millisecond})
+	states := make(chan ConnState, 8)
+	arduino.OnStateChange(func(state ConnState, err error) { states <- state })
+	if err := arduino.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer arduino.Disconnect()
+	<-states
+
+	board := <-boards
+	if err := arduino.DigitalWrite(13, 1); err != nil {
+		t.Fatal(err)
+	}
+	syncPin(t, arduino, 13)
+	if v := board.Pin(13).Value; v != 1 {
+		t.Fatalf("pin 13 = %d, want 1", v)
+	}
+
+	// Drop the connection from the board side
+	board.Close()
+	for _, want := range []ConnState{StateReconnecting, StateConnected} {
+		select {
+		case state := <-states:
+			if state != want {
+				t.Fatalf("state = %v, want %v", state, want)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("no %v state", want)
+		}
+	}
+	board = <-boards
+	syncPin(t, arduino, 13)
+	if v := board.Pin(13).Value; v != 1 {
+		t.Errorf("pin 13 = %d after reconnect, want 1", v)
+	}
+}
+
+func TestTCPReconnectStreams(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	boards := make(chan *sim.Board, 4)
+	go serveTCP(ln, boards)
+
+	arduino, err := New("tcp", WithPort("tcp://"+ln.Addr().String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	arduino.SetReconnect(ReconnectConfig{MinBackoff: 10 * time.Millisecond, MaxBackoff: 50 * time.Millisecond})
+	states := make(chan ConnState, 8)
+	arduino.OnStateChange(func(state ConnState, err error) { states <- state })
+	if err := arduino.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer arduino.Disconnect()
+	<-states
+	board := <-boards
+	board.AddI2CDevice(0x48, 4)
+
+	replies, cancel, err := arduino.I2CSubscribe(0x48, 0, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cancel()
+	port, err := arduino.OpenSerial(HardSerial1, 9600, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer port.Close()
+
+	board.Close()
+	for _, want := range []ConnState{StateReconnecting, StateConnected} {
+		select {
+		case state := <-states:
+			if state != want {
+				t.Fatalf("state = %v, want %v", state, want)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("no %v state", want)
+		}
+	}
+	board = <-boards
+	board.AddI2CDevice(0x48, 4)
+	if err := arduino.I2CWrite(0x48, 0, []byte{0x42}); err != nil {
+		t.Fatal(err)
+	}
+	syncPin(t, arduino, 13)
+
+	if baud := board.SerialBaud(HardSerial1); baud != 9600 {
+		t.Errorf("serial baud = %d after reconnect, want 9600", baud)
+	}
+	board.SerialInput(HardSerial1, []byte("hi"))
+	buf := make([]byte, 2)
+	if _, err := io.ReadFull(port, buf); err != nil || string(buf) != "hi" {
+		t.Errorf("serial Read() = %q, %v, want \"hi\"", buf, err)
+	}
+
+	timeout := time.After(2 * time.Second)
+	for {
+		select {
+		case reply := <-replies:
+			if len(reply.Data) == 1 && reply.Data[0] == 0x42 {
+				return
+			}
+		case <-timeout:
+			t.Fatal("no I2C reply after reconnect")
+		}
+	}
+}
+
+func TestTCPConnectAfterDisconnect(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	boards := make(chan *sim.Board, 4)
+	go serveTCP(ln, boards)
+
+	arduino, err := New("tcp", WithPort("tcp://"+ln.Addr().String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := arduino.Connect(); err != nil {
+			t.Fatalf("connect %d: %v", i+1, err)
+		}
+		<-boards
+		if err := arduino.Disconnect(); err != nil {
+			t.Fatalf("disconnect %d: %v", i+1, err)
+		}
+	}
+}
+
+func TestTCPConnectRefused(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+	arduino, err := New("tcp", WithPort("tcp://"+addr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := arduino.Connect(); err == nil {
+		arduino.Disconnect()
+		t.Fatal("Connect() succeeded without a listener")
+	}
+}
+
+func TestWithDefaultPort(t *testing.T) {
+	tests := []struct{ address, want string }{
+		{"esp.local", "esp.local:3030"},
+		{"esp.local:8080", "esp.local:8080"},
+		{"[::1]", "[::1]:3030"},
+		{"[::1]:8080", "[::1]:8080"},
+	}
+	for _, tt := range tests {
+		if got := withDefaultPort(tt.address, DefaultTCPPort); got != tt.want {
+			t.Errorf("withDefaultPort(%q) = %q, want %q", tt.address, got, tt.want)
+		}
+	}
+}
+
+// quietPort returns io.EOF without data, like a serial port opened with a
+// read timeout when the board sends nothing
+type quietPort struct {
+	timeouts int
+	data     []byte
+	closed   bool
+}
+
+func (p *quietPort) Read(b []byte) (int, error) {
+	if p.closed || p.timeouts == 0 && len(p.data) == 0 {
+		return 0, io.EOF
+	}
+	if p.timeouts > 0 {
+		p.timeouts--
+		return 0, io.EOF
+	}
+	n := copy(b, p.data)
+	p.data = p.data[n:]
+	return n, nil
+}
+
+func (p *quietPort) Write(b []byte) (int, error) { return len(b), nil }
+func (p *quietPort) Close() error                { p.closed = true; return nil }
+
+func TestTimeoutPort(t *testing.T) {
+	port := &timeoutPort{ReadWriteCloser: &quietPort{timeouts: 3, data: []byte{0xF9}}}
+	buf := make([]byte, 4)
+	if n, err := port.Read(buf); n != 1 || err != nil || buf[0] != 0xF9 {
+		t.Fatalf("Read() = %d, %v, % X", n, err, buf[:n])
+	}
+	port.Close()
+	if _, err := port.Read(buf); err != io.EOF {
+		t.Errorf("Read() after Close = %v, want io.EOF", err)
+	}
+}
@@ -0,0 +1,96 @@
+package goduino
+
+import (
+	"fmt"
+	"net"
+	"sync"
+
+	"github.com/argandas/goduino/firmata"
+)
+
+// DefaultUDPPort is used when a udp:// address has no port.
+const DefaultUDPPort = "3030"
+
+// maxDatagram is the largest UDP payload
+const maxDatagram = 65507
+
+// udpConn carries Firmata over a connected UDP socket. Reads return the
+// datagrams as one byte stream, writes are split so every Firmata message
+// or sysex frame travels in a datagram of its own.
+type udpConn struct {
+	net.Conn
+
+	readMu sync.Mutex
+	buf    []byte // unread part of the last datagram
+	data   []byte
+
+	writeMu sync.Mutex
+	pending []byte // start of a message not written completely yet
+}
+
+func newUDPConn(conn net.Conn) *udpConn {
+	return &udpConn{Conn: conn, data: make([]byte, maxDatagram)}
+}
+
+// Read returns the bytes of the datagrams received, in order.
+func (c *udpConn) Read(p []byte) (int, error) {
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+	for len(c.buf) == 0 {
+		n, err := c.Conn.Read(c.data)
+		if err != nil {
+			return 0, err
+		}
+		c.buf = c.data[:n]
+	}
+	n := copy(p, c.buf)
+	c.buf = c.buf[n:]
+	return n, nil
+}
+
+// Write sends every complete message in p as a datagram. A message split
+// across writes is sent once its last byte is written.
+func (c *udpConn) Write(p []byte) (int, error) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	c.pending = append(c.pending, p...)
+	for len(c.pending) > 0 {
+		n := messageLength(c.pending)
+		if n == 0 {
+			if len(c.pending) > maxDatagram {
+				c.pending = nil
+				return 0, fmt.Errorf("firmata message of more than %d bytes doesn't fit in a datagram", maxDatagram)
+			}
+			break
+		}
+		if _, err := c.Conn.Write(c.pending[:n]); err != nil {
+			c.pending = nil
+			return 0, err
+		}
+		c.pending = c.pending[n:]
+	}
+	return len(p), nil
+}
+
+// messageLength returns the length of the host message at the start of
+// buf, or 0 if buf holds only part of it.
+func messageLength(buf []byte) int {
+	n := 1
+	switch cmd := firmata.FirmataCommand(buf[0]); {
+	case cmd == firmata.StartSysex:
+		for i, b := range buf {
+			if firmata.FirmataCommand(b) == firmata.EndSysex {
+				return i + 1
+			}
+		}
+		return 0
+	case cmd&0xF0 == firmata.DigitalMessage, cmd&0xF0 == firmata.AnalogMessage, cmd == firmata.PinMode:
+		n = 3
+	case cmd&0xF0 == firmata.ReportAnalog, cmd&0xF0 == firmata.ReportDigital:
+		n = 2
+	}
+	if len(buf) < n {
+		return 0
+	}
+	return n
+}
@@ -0,0 +1,128 @@
+package goduino
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/argandas/goduino/firmata"
+	"github.com/argandas/goduino/firmata/sim"
+)
+
+// serveUDP bridges the datagrams received on pc to board. It drops the
+// first capability query, so the handshake has to resend it, and reports
+// datagrams that don't hold exactly one message.
+func serveUDP(pc net.PacketConn, board *sim.Board, errs chan<- []byte) {
+	var mu sync.Mutex
+	var peer net.Addr
+	go func() {
+		buf := make([]byte, 4096)
+		for {
+			n, err := board.Read(buf)
+			if err != nil {
+				return
+			}
+			mu.Lock()
+			addr := peer
+			mu.Unlock()
+			if addr != nil {
+				pc.WriteTo(buf[:n], addr)
+			}
+		}
+	}()
+	dropped := false
+	buf := make([]byte, maxDatagram)
+	for {
+		n, addr, err := pc.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		mu.Lock()
+		peer = addr
+		mu.Unlock()
+		datagram := buf[:n]
+		if messageLength(datagram) != n {
+			errs <- append([]byte{}, datagram...)
+		}
+		if !dropped && n == 3 && datagram[1] == byte(firmata.CapabilityQuery) {
+			dropped = true
+			continue
+		}
+		board.Write(datagram)
+	}
+}
+
+func TestUDP(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+	board := sim.NewUno()
+	defer board.Close()
+	errs := make(chan []byte, 16)
+	go serveUDP(pc, board, errs)
+
+	arduino, err := New("udp", WithPort("udp://"+pc.LocalAddr().String()), WithHandshake(HandshakeConfig{
+		StageTimeout:  100 * time.Millisecond,
+		ResetInterval: time.Second,
+		Timeout:       5 * time.Second,
+		QueryRetries:  2,
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := arduino.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer arduino.Disconnect()
+
+	if err := arduino.DigitalWrite(13, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := arduino.PinMode(2, Input); err != nil {
+		t.Fatal(err)
+	}
+	syncPin(t, arduino, 13)
+	if v := board.Pin(13).Value; v != 1 {
+		t.Errorf("pin 13 = %d, want 1", v)
+	}
+	changed := make(chan int, 1)
+	arduino.OnDigitalChange(2, func(old, new int) { changed <- new })
+	board.SetDigital(2, 1)
+	select {
+	case v := <-changed:
+		if v != 1 {
+			t.Errorf("pin 2 = %d, want 1", v)
+		}
+	case <-time.After(time.Second):
+		t.Error("pin 2 change not reported")
+	}
+	select {
+	case datagram := <-errs:
+		t.Errorf("datagram doesn't hold one message: % X", datagram)
+	default:
+	}
+}
+
+func TestMessageLength(t *testing.T) {
+	tests := []struct {
+		buf  []byte
+		want int
+	}{
+		{[]byte{0xF0, 0x79}, 0},
+		{[]byte{0xF0, 0x79, 0xF7, 0x90}, 3},
+		{[]byte{0x91, 0x01}, 0},
+		{[]byte{0x91, 0x01, 0x00}, 3},
+		{[]byte{0xF4, 13, 1, 0xF9}, 3},
+		{[]byte{0xC0}, 0},
+		{[]byte{0xD0, 1}, 2},
+		{[]byte{0xF9, 0xFF}, 1},
+	}
+	for _, tt := range tests {
+		if got := messageLength(tt.buf); got != tt.want {
+			t.Errorf("messageLength(% X) = %d, want %d", tt.buf, got, tt.want)
+		}
+	}
+}